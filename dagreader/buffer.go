@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/Fantom-foundation/go-opera/logger"
 	"github.com/Fantom-foundation/lachesis-base/gossip/dagordering"
@@ -25,26 +26,64 @@ type EventsBuffer struct {
 
 	ordering *dagordering.EventsBuffer
 
-	output chan *internal.EventInfo
-	busy   sync.WaitGroup
+	output chan<- *internal.EventInfo
+	synced bool
+	// inflight are the events passed to db but not written yet
+	inflight struct {
+		count   int64
+		waiters []chan struct{}
+		sync.Mutex
+	}
+	// failed is closed when db loader dies with err
+	failed chan struct{}
+	err    error
 	sync.RWMutex
 
 	logger.Instance
 }
 
-func NewEventsBuffer(db internal.Db, done <-chan struct{}) *EventsBuffer {
+// BufferOptions of EventsBuffer.
+type BufferOptions struct {
+	// Synced mode waits until each event is written into db before the next one,
+	// it is safer but slower than the async mode.
+	Synced bool
+	// Spill events to temporary file instead of blocking when db is slower than producer.
+	Spill bool
+	// Workers to write events concurrently, 1 or less means the sequential Load.
+	Workers int
+}
+
+// NewEventsBuffer orders events and passes them to db.
+func NewEventsBuffer(db internal.Db, opts BufferOptions, done <-chan struct{}) *EventsBuffer {
 	const count = 3000
 
 	s := &EventsBuffer{
 		db:       db,
-		output:   make(chan *internal.EventInfo, 10),
+		synced:   opts.Synced,
+		failed:   make(chan struct{}),
 		Instance: logger.New("buffer"),
 	}
 
 	s.events.processed = make(map[idx.Epoch]map[hash.Event]dag.Event, 3)
 	s.events.info = make(map[hash.Event]*internal.EventInfo, count)
 
-	go db.Load(s.output)
+	var input <-chan *internal.EventInfo
+	if opts.Spill {
+		q := newSpillQueue(count, done, s.release)
+		s.output = q.In()
+		input = q.Out()
+	} else {
+		output := make(chan *internal.EventInfo, 10)
+		s.output = output
+		input = output
+	}
+	go s.load(func() {
+		if opts.Workers > 1 {
+			db.LoadParallel(input, opts.Workers)
+		} else {
+			db.Load(input)
+		}
+	})
 
 	s.ordering = dagordering.New(dag.Metric{
 		Num:  count,
@@ -62,13 +101,40 @@ func NewEventsBuffer(db internal.Db, done <-chan struct{}) *EventsBuffer {
 				delete(s.events.processed, epoch-2)
 			}
 
+			s.acquire()
+			release := s.release
+
+			written := make(chan struct{})
+			dispose := info.Dispose
+			info.Dispose = func() {
+				if dispose != nil {
+					dispose()
+				}
+				release()
+				close(written)
+			}
+
 			s.Log.Debug("completed event", "id", id)
 			select {
 			case s.output <- info:
 				s.events.processed[epoch][id] = e
 				delete(s.events.info, id)
 			case <-done:
+				release()
 				return fmt.Errorf("Interrupted")
+			case <-s.failed:
+				release()
+				return s.err
+			}
+
+			if s.synced {
+				select {
+				case <-written:
+				case <-done:
+					return fmt.Errorf("Interrupted")
+				case <-s.failed:
+					return s.err
+				}
 			}
 
 			return nil
@@ -96,7 +162,11 @@ func NewEventsBuffer(db internal.Db, done <-chan struct{}) *EventsBuffer {
 			}
 
 			if len(s.events.processed) < 2 {
-				info := s.db.GetEvent(e)
+				info, err := s.db.GetEvent(e)
+				if err != nil {
+					// nil would be a missing parent, so the valid events wait for it forever
+					s.Log.Crit("failed to get event", "id", e, "err", err)
+				}
 				if info != nil {
 					return info.Event
 				}
@@ -114,6 +184,29 @@ func NewEventsBuffer(db internal.Db, done <-chan struct{}) *EventsBuffer {
 	return s
 }
 
+// load runs db loader, its panic doesn't crash the process but fails the buffer.
+func (s *EventsBuffer) load(loader func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.Log.Error("db loader failed", "err", r)
+			s.err = fmt.Errorf("db loader panic: %v", r)
+			close(s.failed)
+		}
+	}()
+
+	loader()
+}
+
+// Err returns the error of the died db loader, the buffer doesn't write events after it.
+func (s *EventsBuffer) Err() error {
+	select {
+	case <-s.failed:
+		return s.err
+	default:
+		return nil
+	}
+}
+
 func (s *EventsBuffer) Push(e *internal.EventInfo) {
 	s.Lock()
 	defer s.Unlock()
@@ -129,3 +222,62 @@ func (s *EventsBuffer) Close() {
 	close(s.output)
 	s.ordering.Clear()
 }
+
+func (s *EventsBuffer) acquire() {
+	s.inflight.Lock()
+	defer s.inflight.Unlock()
+
+	s.inflight.count++
+}
+
+func (s *EventsBuffer) release() {
+	s.inflight.Lock()
+	defer s.inflight.Unlock()
+
+	s.inflight.count--
+	if s.inflight.count == 0 {
+		for _, w := range s.inflight.waiters {
+			close(w)
+		}
+		s.inflight.waiters = nil
+	}
+}
+
+// idle returns channel which is closed when there are no events in flight.
+func (s *EventsBuffer) idle() <-chan struct{} {
+	s.inflight.Lock()
+	defer s.inflight.Unlock()
+
+	w := make(chan struct{})
+	if s.inflight.count == 0 {
+		close(w)
+	} else {
+		s.inflight.waiters = append(s.inflight.waiters, w)
+	}
+	return w
+}
+
+// WaitForAll blocks until all the events passed to db are written or db loader fails.
+func (s *EventsBuffer) WaitForAll() {
+	select {
+	case <-s.idle():
+	case <-s.failed:
+	}
+}
+
+// WaitForAllTimeout is a WaitForAll which gives up after d,
+// so shutdown doesn't hang on unresponsive db.
+func (s *EventsBuffer) WaitForAllTimeout(d time.Duration) error {
+	select {
+	case <-s.idle():
+		return nil
+	case <-s.failed:
+		return s.err
+	case <-time.After(d):
+		s.inflight.Lock()
+		lost := s.inflight.count
+		s.inflight.Unlock()
+		s.Log.Error("events are not written", "count", lost, "timeout", d)
+		return fmt.Errorf("%d events are not written in %s", lost, d)
+	}
+}
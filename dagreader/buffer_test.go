@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// stubDb writes event when it gets a signal from release.
+type stubDb struct {
+	release chan struct{}
+	written chan hash.Event
+}
+
+func newStubDb() *stubDb {
+	return &stubDb{
+		release: make(chan struct{}, 100),
+		written: make(chan hash.Event, 100),
+	}
+}
+
+func (db *stubDb) GetLastBlock() idx.Block                          { return 1 }
+func (db *stubDb) HasEvent(hash.Event) bool                         { return false }
+func (db *stubDb) GetEvent(hash.Event) (*internal.EventInfo, error) { return nil, nil }
+
+func (db *stubDb) Load(events <-chan *internal.EventInfo) {
+	for info := range events {
+		<-db.release
+		info.Done()
+		db.written <- info.Event.ID()
+	}
+}
+
+func (db *stubDb) LoadParallel(events <-chan *internal.EventInfo, workers int) {
+	db.Load(events)
+}
+
+func genesisEvent(creator idx.ValidatorID) *internal.EventInfo {
+	e := &inter.MutableEventPayload{}
+	e.SetCreator(creator)
+	e.SetSeq(1)
+	e.SetLamport(1)
+	return &internal.EventInfo{
+		Block: 1,
+		Event: &e.Build().Event,
+	}
+}
+
+func TestEventsBufferSynced(t *testing.T) {
+	require := require.New(t)
+
+	db := newStubDb()
+	done := make(chan struct{})
+	defer close(done)
+	buffer := NewEventsBuffer(db, BufferOptions{Synced: true}, done)
+
+	pushed := make(chan struct{})
+	go func() {
+		buffer.Push(genesisEvent(1))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+		require.Fail("Push doesn't wait until event is written")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	db.release <- struct{}{}
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		require.Fail("Push isn't released after event is written")
+	}
+}
+
+func TestEventsBufferAsync(t *testing.T) {
+	require := require.New(t)
+
+	db := newStubDb()
+	done := make(chan struct{})
+	defer close(done)
+	buffer := NewEventsBuffer(db, BufferOptions{}, done)
+
+	pushed := make(chan struct{})
+	go func() {
+		buffer.Push(genesisEvent(1))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		require.Fail("Push waits until event is written")
+	}
+
+	db.release <- struct{}{}
+	<-db.written
+}
+
+func TestEventsBufferWaitForAllTimeout(t *testing.T) {
+	require := require.New(t)
+
+	db := newStubDb()
+	done := make(chan struct{})
+	defer close(done)
+	buffer := NewEventsBuffer(db, BufferOptions{}, done)
+
+	require.NoError(buffer.WaitForAllTimeout(time.Second))
+
+	buffer.Push(genesisEvent(1))
+	buffer.Push(genesisEvent(2))
+	require.Error(buffer.WaitForAllTimeout(100 * time.Millisecond))
+
+	db.release <- struct{}{}
+	db.release <- struct{}{}
+	require.NoError(buffer.WaitForAllTimeout(time.Second))
+}
+
+// panicDb fails on the first event.
+type panicDb struct {
+	stubDb
+}
+
+func (db *panicDb) Load(events <-chan *internal.EventInfo) {
+	<-events
+	panic("unexpected value")
+}
+
+func TestEventsBufferLoadPanic(t *testing.T) {
+	require := require.New(t)
+
+	db := &panicDb{}
+	done := make(chan struct{})
+	defer close(done)
+	buffer := NewEventsBuffer(db, BufferOptions{Synced: true}, done)
+	require.NoError(buffer.Err())
+
+	pushed := make(chan struct{})
+	go func() {
+		buffer.Push(genesisEvent(1))
+		buffer.Push(genesisEvent(2))
+		close(pushed)
+	}()
+
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		require.Fail("Push hangs after db loader died")
+	}
+	require.Error(buffer.Err())
+	require.Error(buffer.WaitForAllTimeout(time.Second))
+}
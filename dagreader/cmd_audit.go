@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/neo4j"
+)
+
+var cmdAudit = cli.Command{
+	Name:      "audit",
+	ArgsUsage: "<epoch> [<epoch>...]",
+	Flags: []cli.Flag{
+		neo4jUrlFlag,
+		neo4jWaitFlag,
+		neo4jPrefixFlag,
+	},
+	Action: cmd(actAudit),
+	Usage:  "Check that ids of the epochs events match the hashes of the stored events.",
+}
+
+func actAudit(ctx context.Context, cli *cli.Context) error {
+	if cli.NArg() == 0 {
+		return fmt.Errorf("no epochs")
+	}
+	epochs := make([]idx.Epoch, cli.NArg())
+	for i, arg := range cli.Args() {
+		n, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid epoch %q", arg)
+		}
+		epochs[i] = idx.Epoch(n)
+	}
+
+	disk := cli.String(neo4jUrlFlag.Name)
+	log.Info("open DB", "path", disk)
+	db, err := neo4j.New(disk, neo4j.Options{
+		ConnectRetry: neo4j.RetrySpec{
+			Timeout: cli.Duration(neo4jWaitFlag.Name),
+		},
+		LabelPrefix: cli.String(neo4jPrefixFlag.Name),
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var mismatched int
+	for _, epoch := range epochs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		ids, err := db.AuditHashes(epoch)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			log.Error("event hash mismatch", "id", id)
+		}
+		log.Info("epoch audited", "epoch", epoch, "mismatched", len(ids))
+		mismatched += len(ids)
+	}
+	if mismatched > 0 {
+		return fmt.Errorf("%d events don't match their hashes", mismatched)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/neo4j"
+)
+
+var cmdImport = cli.Command{
+	Name:      "import",
+	ArgsUsage: "<file> [<file>...]",
+	Flags: []cli.Flag{
+		neo4jUrlFlag,
+		neo4jWaitFlag,
+		neo4jPrefixFlag,
+		neo4jMergeFlag,
+		workersFlag,
+	},
+	Action: cmd(actImport),
+	Usage:  "Write DAG of the `opera export events` files into db.",
+}
+
+func actImport(ctx context.Context, cli *cli.Context) error {
+	if cli.NArg() == 0 {
+		return fmt.Errorf("no events files")
+	}
+
+	disk := cli.String(neo4jUrlFlag.Name)
+	log.Info("open DB", "path", disk)
+	opts := neo4j.Options{
+		ConnectRetry: neo4j.RetrySpec{
+			Timeout: cli.Duration(neo4jWaitFlag.Name),
+		},
+		LabelPrefix: cli.String(neo4jPrefixFlag.Name),
+	}
+	if cli.Bool(neo4jMergeFlag.Name) {
+		opts.EdgeWriteMode = neo4j.MergeIdempotent
+	}
+	db, err := neo4j.New(disk, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return ImportFiles(cli.Args(), db, cli.Int(workersFlag.Name))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/neo4j"
+)
+
+var cmdMigrate = cli.Command{
+	Name: "migrate",
+	Flags: []cli.Flag{
+		neo4jUrlFlag,
+		neo4jWaitFlag,
+		neo4jPrefixFlag,
+	},
+	Action: cmd(actMigrate),
+	Usage:  "Upgrade db written by the older versions.",
+}
+
+func actMigrate(ctx context.Context, cli *cli.Context) error {
+	disk := cli.String(neo4jUrlFlag.Name)
+	log.Info("open DB", "path", disk)
+	db, err := neo4j.New(disk, neo4j.Options{
+		ConnectRetry: neo4j.RetrySpec{
+			Timeout: cli.Duration(neo4jWaitFlag.Name),
+		},
+		LabelPrefix: cli.String(neo4jPrefixFlag.Name),
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Migrate()
+}
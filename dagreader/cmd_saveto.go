@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"time"
 
 	"github.com/Fantom-foundation/lachesis-base/inter/idx"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/urfave/cli"
 
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
 	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/neo4j"
 )
 
@@ -17,30 +19,89 @@ var (
 		Value: neo4j.DefaultDb,
 	}
 
+	syncedFlag = cli.BoolFlag{
+		Name:  "synced",
+		Usage: "wait until each event is written into db (safer, slower)",
+	}
+
+	spillFlag = cli.BoolFlag{
+		Name:  "spill",
+		Usage: "spill events to temporary file when db is slower than API",
+	}
+
+	workersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of concurrent db writers, event is written after its parents",
+		Value: 1,
+	}
+
+	neo4jWaitFlag = cli.DurationFlag{
+		Name:  "neo4j.wait",
+		Usage: "time to wait until Neo4j DB is up",
+	}
+
+	neo4jPrefixFlag = cli.StringFlag{
+		Name:  "neo4j.prefix",
+		Usage: "Neo4j node labels prefix to keep several datasets in one db",
+	}
+
+	neo4jMergeFlag = cli.BoolFlag{
+		Name:  "neo4j.merge",
+		Usage: "merge PARENT edges instead of creating (slower, safe for resumed imports)",
+	}
+
 	cmdSaveTo = cli.Command{
 		Name: "saveto",
 		Flags: []cli.Flag{
 			neo4jUrlFlag,
+			neo4jWaitFlag,
+			neo4jPrefixFlag,
+			neo4jMergeFlag,
+			syncedFlag,
+			spillFlag,
+			workersFlag,
 		},
 		Action: cmd(actSaveTo),
 		Usage:  "Write DAG into db.",
 	}
 )
 
+// shutdownTimeout bounds waiting for the events to be written on exit.
+const shutdownTimeout = 30 * time.Second
+
 func actSaveTo(ctx context.Context, cli *cli.Context) error {
 	disk := cli.String(neo4jUrlFlag.Name)
 	log.Info("open DB", "path", disk)
-	db, err := neo4j.New(disk)
+	opts := neo4j.Options{
+		ConnectRetry: neo4j.RetrySpec{
+			Timeout: cli.Duration(neo4jWaitFlag.Name),
+		},
+		LabelPrefix: cli.String(neo4jPrefixFlag.Name),
+	}
+	if cli.Bool(neo4jMergeFlag.Name) {
+		opts.EdgeWriteMode = neo4j.MergeIdempotent
+	}
+	db, err := neo4j.New(disk, opts)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	buffer := NewEventsBuffer(db, ctx.Done())
-	defer buffer.Close()
+	return ImportLive(ctx, cli.GlobalString(operaApiUrlFlag.Name), idx.Block(cli.GlobalUint64(dagStartFlag.Name)), db, BufferOptions{
+		Synced:  cli.Bool(syncedFlag.Name),
+		Spill:   cli.Bool(spillFlag.Name),
+		Workers: cli.Int(workersFlag.Name),
+	})
+}
 
-	rpc := cli.GlobalString(operaApiUrlFlag.Name)
-	dagStart := idx.Block(cli.GlobalUint64(dagStartFlag.Name))
+// ImportLive mirrors DAG of the running opera node into db until ctx is done.
+// It resumes from the last block in db (or dagStart) and reconnects to the node API on failures.
+func ImportLive(ctx context.Context, rpc string, dagStart idx.Block, db internal.Db, opts BufferOptions) error {
+	buffer := NewEventsBuffer(db, opts, ctx.Done())
+	defer func() {
+		buffer.Close()
+		_ = buffer.WaitForAllTimeout(shutdownTimeout)
+	}()
 
 	log.Info("connect to API", "url", rpc)
 	reader := NewReader(rpc, dagStart, db)
@@ -50,10 +111,11 @@ func actSaveTo(ctx context.Context, cli *cli.Context) error {
 		select {
 		case e := <-reader.Events():
 			buffer.Push(e)
+			if err := buffer.Err(); err != nil {
+				return err
+			}
 		case <-ctx.Done():
 			return nil
 		}
 	}
-
-	return nil
 }
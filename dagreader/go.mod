@@ -8,10 +8,13 @@ require (
 	github.com/ethereum/go-ethereum v1.9.22
 	github.com/hashicorp/golang-lru v0.5.5-0.20210104140557-80c98217689d
 	github.com/neo4j/neo4j-go-driver v1.8.3
+	github.com/ory/dockertest/v3 v3.6.5
 	github.com/paulbellamy/ratecounter v0.2.0
 	github.com/stretchr/testify v1.7.0
 	github.com/syndtr/goleveldb v1.0.1-0.20210305035536-64b5b1c73954
 	github.com/urfave/cli v1.22.1
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
 	gopkg.in/urfave/cli.v1 v1.22.1 // indirect
 )
 
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// eventsFileHeader starts the file of `opera export events`: magic and version.
+var eventsFileHeader = []byte{0x7e, 0x99, 0x56, 0x78, 0x00, 0x01, 0x00, 0x01}
+
+// importProgress is a number of events between the per-file progress reports.
+const importProgress = 100000
+
+// ImportFiles loads events from the files of `opera export events` (gzipped if named *.gz) into db.
+// Files are read concurrently and their events are merged, the events which are in several files
+// or in db already are skipped. Event is written after its parents by db workers.
+func ImportFiles(paths []string, db internal.Db, workers int) error {
+	var (
+		events = make(chan *internal.EventInfo, 100)
+		files  = make(chan string)
+		dedup  = newEventsDedup(db)
+		wg     sync.WaitGroup
+
+		failed = make(chan struct{})
+		once   sync.Once
+		err    error
+	)
+	fail := func(e error) {
+		once.Do(func() {
+			err = e
+			close(failed)
+		})
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	readers := workers
+	if readers > len(paths) {
+		readers = len(paths)
+	}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range files {
+				if err := readEventsFile(path, events, dedup, failed); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(files)
+		for _, path := range paths {
+			select {
+			case files <- path:
+			case <-failed:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	db.LoadParallel(events, workers)
+
+	return err
+}
+
+// readEventsFile sends the new events of file to events until it is read or import is failed.
+func readEventsFile(path string, events chan<- *internal.EventInfo, dedup *eventsDedup, failed <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	header := make([]byte, len(eventsFileHeader))
+	if _, err = io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, eventsFileHeader) {
+		return errors.New("not an events file: " + path)
+	}
+
+	var total, skipped int
+	stream := rlp.NewStream(r, 0)
+	for {
+		e := new(inter.EventPayload)
+		err = stream.Decode(e)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		total++
+		if total%importProgress == 0 {
+			log.Info("import events", "file", path, "events", total, "skipped", skipped, "last", e.ID())
+		}
+		if !dedup.first(e.ID()) {
+			skipped++
+			continue
+		}
+
+		select {
+		case events <- &internal.EventInfo{Event: e}:
+		case <-failed:
+			return nil
+		}
+	}
+
+	log.Info("imported events file", "file", path, "events", total, "skipped", skipped)
+	return nil
+}
+
+// eventsDedup passes event once, the events in db are not passed.
+type eventsDedup struct {
+	db   internal.Storage
+	seen map[hash.Event]struct{}
+	mu   sync.Mutex
+}
+
+func newEventsDedup(db internal.Storage) *eventsDedup {
+	return &eventsDedup{
+		db:   db,
+		seen: make(map[hash.Event]struct{}),
+	}
+}
+
+// first returns true only the first time event is passed.
+func (d *eventsDedup) first(e hash.Event) bool {
+	d.mu.Lock()
+	_, seen := d.seen[e]
+	d.seen[e] = struct{}{}
+	d.mu.Unlock()
+
+	return !seen && !d.db.HasEvent(e)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// collectDb keeps the loaded events.
+type collectDb struct {
+	stubDb
+	existing hash.Event
+	loaded   []hash.Event
+	mu       sync.Mutex
+}
+
+func (db *collectDb) HasEvent(e hash.Event) bool { return e == db.existing }
+
+func (db *collectDb) LoadParallel(events <-chan *internal.EventInfo, workers int) {
+	for info := range events {
+		db.mu.Lock()
+		db.loaded = append(db.loaded, info.Event.ID())
+		db.mu.Unlock()
+	}
+}
+
+func creatorChain(creator idx.ValidatorID, n int) []*inter.EventPayload {
+	var (
+		events []*inter.EventPayload
+		parent *hash.Event
+	)
+	for seq := 1; seq <= n; seq++ {
+		e := &inter.MutableEventPayload{}
+		e.SetEpoch(1)
+		e.SetCreator(creator)
+		e.SetSeq(idx.Event(seq))
+		e.SetLamport(idx.Lamport(seq))
+		if parent != nil {
+			e.SetParents(hash.Events{*parent})
+		}
+		event := e.Build()
+		id := event.ID()
+		parent = &id
+		events = append(events, event)
+	}
+	return events
+}
+
+func writeEventsFile(t *testing.T, path string, events []*inter.EventPayload) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var w io.Writer = f
+	if filepath.Ext(path) == ".gz" {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	_, err = w.Write(eventsFileHeader)
+	require.NoError(t, err)
+	for _, e := range events {
+		require.NoError(t, rlp.Encode(w, e))
+	}
+}
+
+func TestImportFiles(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "dagreader-import-")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	a, b := creatorChain(1, 10), creatorChain(2, 10)
+	// overlapping dumps
+	writeEventsFile(t, filepath.Join(dir, "a.rlp"), append(a[:6:6], b[:3]...))
+	writeEventsFile(t, filepath.Join(dir, "b.rlp.gz"), append(a[4:], b...))
+
+	db := &collectDb{existing: b[0].ID()}
+	err = ImportFiles([]string{filepath.Join(dir, "a.rlp"), filepath.Join(dir, "b.rlp.gz")}, db, 2)
+	require.NoError(err)
+
+	var expect []hash.Event
+	for _, e := range append(a, b[1:]...) {
+		expect = append(expect, e.ID())
+	}
+	require.ElementsMatch(expect, db.loaded)
+
+	bad := filepath.Join(dir, "bad.rlp")
+	require.NoError(ioutil.WriteFile(bad, []byte("not events"), 0600))
+	require.Error(ImportFiles([]string{bad}, &collectDb{}, 1))
+}
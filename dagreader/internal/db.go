@@ -9,12 +9,13 @@ import (
 type Storage interface {
 	GetLastBlock() idx.Block
 	HasEvent(hash.Event) bool
-	GetEvent(hash.Event) *EventInfo
+	GetEvent(hash.Event) (*EventInfo, error)
 }
 
 type Db interface {
 	Storage
 	Load(events <-chan *EventInfo)
+	LoadParallel(events <-chan *EventInfo, workers int)
 }
 
 type EventInfo struct {
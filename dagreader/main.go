@@ -38,6 +38,9 @@ func init() {
 	}
 	App.Commands = []cli.Command{
 		cmdSaveTo,
+		cmdMigrate,
+		cmdImport,
+		cmdAudit,
 	}
 }
 
@@ -64,11 +67,16 @@ func jobContext() (ctx context.Context, cancel func()) {
 	go func() {
 		select {
 		case <-sigs:
-			log.Warn("Interrupted")
+			// the job writes the events in flight before exit, it takes a while
+			log.Warn("Interrupted, interrupt again to exit immediately")
 			cancel()
 		case <-ctx.Done():
 			log.Info("Finished")
+			return
 		}
+
+		<-sigs
+		log.Crit("Interrupted again, exit without waiting")
 	}()
 
 	return
@@ -1,8 +1,12 @@
 package neo4j
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Fantom-foundation/go-opera/logger"
@@ -13,6 +17,7 @@ import (
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"github.com/paulbellamy/ratecounter"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
 )
@@ -23,43 +28,160 @@ const (
 	// statsReportLimit is the time limit during import and export after which we
 	// always print out progress. This avoids the user wondering what's going on.
 	statsReportLimit = 8 * time.Second
+
+	// closeTimeout bounds waiting for the running queries on Close.
+	closeTimeout = 30 * time.Second
 )
 
+// ErrNotFound is returned when the requested event is not in db.
+var ErrNotFound = errors.New("event not found")
+
+// ErrUnsupported is returned by the methods which need the procedures or features the server doesn't have.
+var ErrUnsupported = errors.New("not supported by the server")
+
+// ErrTraversalTooLarge is wrapped by TraversalTooLargeError.
+var ErrTraversalTooLarge = errors.New("traversal result is too large")
+
+// TraversalTooLargeError is returned when traversal finds more than Options.MaxTraversalResults events.
+// Use StreamAncestors or the bounded depth queries for such events.
+type TraversalTooLargeError struct {
+	// Partial is a number of events read before the traversal is stopped.
+	Partial int
+}
+
+func (e *TraversalTooLargeError) Error() string {
+	return fmt.Sprintf("%s: more than %d events", ErrTraversalTooLarge, e.Partial)
+}
+
+func (e *TraversalTooLargeError) Unwrap() error {
+	return ErrTraversalTooLarge
+}
+
 type Db struct {
-	drv   neo4j.Driver
-	busy  sync.WaitGroup
-	cache struct {
+	drv             neo4j.Driver
+	labeler         *labeler
+	maxTraversal    int
+	throttle        *tokenBucket
+	edgeWrite       string
+	ids             IdEncoding
+	rootLabel       bool
+	compressPayload bool
+	trustedBulk     bool
+	tracer          trace.Tracer
+	batch           batchLimits
+	dialect         dialect
+	onDeadLetter    func(*internal.EventInfo, error)
+	deadLetters     int64
+	swallowed       struct {
+		counts map[string]int64
+		sync.Mutex
+	}
+	bookmarks *bookmarks
+	busy      sync.WaitGroup
+	cache     struct {
 		EventInfos *lru.Cache
 	}
 
 	logger.Instance
 }
 
-func New(dbUrl string) (*Db, error) {
-	db, err := neo4j.NewDriver(dbUrl, neo4j.NoAuth(), func(c *neo4j.Config) {
-		c.Encrypted = false
-	})
+func New(dbUrl string, opts Options) (*Db, error) {
+	if opts.ParentRelType != "" && !relTypeName.MatchString(opts.ParentRelType) {
+		return nil, fmt.Errorf("invalid parent relationship type %q", opts.ParentRelType)
+	}
+
+	target, configure, err := driverTarget(dbUrl)
+	if err != nil {
+		return nil, err
+	}
+	db, err := neo4j.NewDriver(target, neo4j.NoAuth(), configure)
 	if err != nil {
 		return nil, err
 	}
 
 	s := &Db{
-		drv:      db,
-		Instance: logger.New("neo4j"),
+		drv:             db,
+		labeler:         newLabeler(opts),
+		maxTraversal:    opts.maxTraversalResults(),
+		throttle:        opts.throttle(),
+		edgeWrite:       opts.EdgeWriteMode.clause(),
+		ids:             opts.IdEncoding,
+		rootLabel:       opts.RootLabel,
+		compressPayload: opts.CompressPayload,
+		trustedBulk:     opts.TrustedBulk,
+		tracer:          opts.Tracer,
+		batch:           opts.batchLimits(),
+		onDeadLetter:    opts.DeadLetter,
+		bookmarks:       opts.bookmarks(),
+		Instance:        logger.New("neo4j"),
+	}
+
+	err = opts.ConnectRetry.Do(db.VerifyConnectivity, func(err error, delay time.Duration) {
+		s.Log.Warn("db is not ready, retry", "url", dbUrl, "err", err, "delay", delay)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.dialect = s.serverDialect()
+	err = s.setup()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	progress := opts.IndexProgress
+	if progress == nil {
+		progress = s.logIndexProgress
+	}
+	err = s.awaitIndexes(progress)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s.cache.EventInfos, err = lru.New(500)
+	if err != nil {
+		panic(err)
 	}
 
+	err = s.checkSchema()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// setup creates the indexes and the initial state, the existing ones are kept.
+func (s *Db) setup() error {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := db.Session(neo4j.AccessModeWrite)
+	session, err := s.session(neo4j.AccessModeWrite)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer session.Close()
 
+	idDDL := "CREATE CONSTRAINT ON (e:Event) ASSERT e.id IS UNIQUE"
+	if s.trustedBulk {
+		// EnsureConstraints replaces it with the constraint
+		idDDL = "CREATE INDEX ON :Event(id)"
+	}
 	DDLs := []string{
-		"CREATE CONSTRAINT ON (e:Event) ASSERT e.id IS UNIQUE",
+		idDDL,
 		"CREATE CONSTRAINT ON (b:Block) ASSERT b.id IS UNIQUE",
+		"CREATE INDEX ON :Event(epoch)",
+		"CREATE INDEX ON :Event(finalized)",
+		"CREATE INDEX ON :Event(creator)",
+		"CREATE INDEX ON :Event(epoch, frame)",
+		"CREATE INDEX ON :Event(epoch, creator)",
+		"CREATE INDEX ON :Event(epoch, creation_time)",
+		"CREATE INDEX ON :Event(epoch, lamport)",
+		"CREATE INDEX ON :Event(imported_at)",
+		"CREATE INDEX ON :Root(epoch)",
 		"CREATE (s:State {id:'last', block:1})",
 	}
 	for _, query := range DDLs {
@@ -75,20 +197,27 @@ func New(dbUrl string) (*Db, error) {
 			return nil, ctx.Commit()
 		})
 		if err != nil {
-			ignoreFakeError(err)
+			s.ignoreFakeError(err)
 		}
 	}
 
-	s.cache.EventInfos, err = lru.New(500)
-	if err != nil {
-		panic(err)
-	}
-
-	return s, nil
+	return nil
 }
 
+// Close waits for the running queries no longer than closeTimeout and closes db.
 func (s *Db) Close() error {
-	s.busy.Wait()
+	finished := make(chan struct{})
+	go func() {
+		s.busy.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(closeTimeout):
+		s.Log.Error("db is busy, close anyway", "timeout", closeTimeout)
+	}
+
 	return s.drv.Close()
 }
 
@@ -101,7 +230,7 @@ func (s *Db) HasEvent(e hash.Event) bool {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := s.drv.Session(neo4j.AccessModeRead)
+	session, err := s.session(neo4j.AccessModeRead)
 	if err != nil {
 		panic(err)
 	}
@@ -109,7 +238,7 @@ func (s *Db) HasEvent(e hash.Event) bool {
 
 	res, err := session.ReadTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
 		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e`, fields{
-			"id": eventId2str(e),
+			"id": s.ids.str(e),
 		})
 		if err != nil {
 			panic(err)
@@ -119,79 +248,76 @@ func (s *Db) HasEvent(e hash.Event) bool {
 		return has, nil
 	})
 	if err != nil {
-		ignoreFakeError(err)
+		s.ignoreFakeError(err)
 	}
 
 	return res.(bool)
 }
 
-// GetEvent returns event info.
-func (s *Db) GetEvent(e hash.Event) *internal.EventInfo {
+// GetEvent returns event info, or nil if event is not in db.
+// Error means the query failed, the event may exist.
+func (s *Db) GetEvent(e hash.Event) (info *internal.EventInfo, err error) {
+	span := s.span("GetEvent")
+	span.SetAttribute("event", e.String())
+	defer func() {
+		span.End(err)
+	}()
+
 	// Get event from LRU cache first.
 	if ev, ok := s.cache.EventInfos.Get(e); ok {
-		return ev.(*internal.EventInfo)
+		span.SetAttribute("cached", true)
+		return ev.(*internal.EventInfo), nil
 	}
 
-	s.busy.Add(1)
-	defer s.busy.Done()
-
-	session, err := s.drv.Session(neo4j.AccessModeRead)
-	if err != nil {
-		panic(err)
-	}
-	defer session.Close()
-
-	res, err := session.ReadTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
-		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.block as block, e.role as role, e.id as id, e.creator as creator`, fields{
-			"id": eventId2str(e),
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e`, fields{
+			"id": s.ids.str(e),
 		})
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-
-		for cursor.Next() {
-			ff := readFields(cursor.Record())
-			return ff, nil
+		if !cursor.Next() {
+			return nil, cursor.Err()
 		}
-		return nil, nil
+
+		ff := fields(cursor.Record().GetByIndex(0).(neo4j.Node).Props())
+		withParents(ff, func() hash.Events {
+			var parents hash.Events
+			parents, err = s.readParents(ctx, e)
+			return parents
+		})
+		return ff, err
 	})
-	if err != nil {
-		ignoreFakeError(err)
-	}
-	if res == nil {
-		return nil
+	if err != nil || res == nil {
+		return nil, err
 	}
 
-	ff := res.(fields)
-	ff["parents"] = s.getParents(session, e)
+	info = new(internal.EventInfo)
+	unmarshal(res.(fields), info)
+	// the whole event with parents, so the next GetEvent doesn't query db
+	s.cache.EventInfos.Add(e, info)
 
-	info := new(internal.EventInfo)
-	unmarshal(ff, info)
+	return info, nil
+}
 
-	return info
+// withParents sets the parents from edges if node has no parents property.
+// Parents property keeps the original order and is there even if edges are not written,
+// edges are for the events imported before the property was introduced.
+func withParents(ff fields, edges func() hash.Events) {
+	if _, ok := ff["parents"]; !ok {
+		ff["parents"] = edges()
+	}
 }
 
-func (s *Db) getParents(session neo4j.Session, e hash.Event) hash.Events {
-	var parents hash.Events
-	id := eventId2str(e)
-	_, err := session.ReadTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
-		cursor, err := search(ctx, `MATCH (e:Event %s)-[:PARENT]->(p) RETURN p.id`,
-			fields{"id": id},
-		)
-		if err != nil {
-			panic(err)
-		}
-		for cursor.Next() {
-			p := str2eventId(cursor.Record().GetByIndex(0).(string))
-			parents = append(parents, p)
-		}
-		return nil, nil
-	})
+// readParents reads ids of the PARENT edges of event.
+func (s *Db) readParents(ctx neo4j.Transaction, e hash.Event) (hash.Events, error) {
+	cursor, err := search(ctx, `MATCH (e:Event %s)-[:PARENT]->(p) RETURN p.id`,
+		fields{"id": s.ids.str(e)},
+	)
 	if err != nil {
-		ignoreFakeError(err)
+		return nil, err
 	}
-
-	return parents
+	return readEventIds(cursor)
 }
 
 // Load data from input chain.
@@ -199,7 +325,7 @@ func (s *Db) Load(events <-chan *internal.EventInfo) {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := s.drv.Session(neo4j.AccessModeWrite)
+	session, err := s.session(neo4j.AccessModeWrite)
 	if err != nil {
 		panic(err)
 	}
@@ -209,31 +335,65 @@ func (s *Db) Load(events <-chan *internal.EventInfo) {
 	defer close(parents)
 	go s.loadParents(parents)
 
-	var lastBlock idx.Block = s.GetLastBlock()
-	for info := range events {
-		_, err = session.WriteTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
-			defer ctx.Close()
+	var (
+		lastBlock idx.Block = s.GetLastBlock()
+		batch               = newEventsBatch(s.batch)
+		timeout   <-chan time.Time
+	)
+	flush := func() {
+		timeout = nil
+		if batch.empty() {
+			return
+		}
 
-			if lastBlock < info.Block {
-				lastBlock = info.Block
-				s.setLastBlock(lastBlock)
-			}
+		sort.Sort(batch)
 
-			data := marshal(info)
-			delete(data, "parents")
-			s.Log.Debug("<<< event", "id", info.Event.ID(), "data", data)
-			err = exec(ctx, "CREATE (e:Event %s)", data)
-			if err != nil {
-				panic(err)
+		span := s.span("Load")
+		span.SetAttribute("events", len(batch.infos))
+		err := commitTx(session, func(ctx neo4j.Transaction) error {
+			for _, info := range batch.infos {
+				if lastBlock < info.Block {
+					lastBlock = info.Block
+					s.setLastBlock(lastBlock)
+				}
 			}
 
-			return nil, ctx.Commit()
+			for i, info := range batch.infos {
+				s.Log.Debug("<<< event", "id", info.Event.ID(), "data", batch.data[i])
+				err := exec(ctx, "CREATE (e%s %s) SET e.imported_at = timestamp()", s.eventLabels(info.Event), batch.data[i])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
 		})
-		if err != nil {
-			ignoreFakeError(err)
+		span.End(err)
+
+		for _, info := range batch.infos {
+			if err != nil {
+				s.deadLetter(info, err)
+			}
+			parents <- info
 		}
+		batch.reset()
+	}
+	defer flush()
 
-		parents <- info
+	for {
+		select {
+		case info, ok := <-events:
+			if !ok {
+				return
+			}
+			s.throttle.Wait()
+			if batch.add(info, s.marshal(info).String()) {
+				flush()
+			} else if timeout == nil {
+				timeout = time.After(s.batch.delay)
+			}
+		case <-timeout:
+			flush()
+		}
 	}
 }
 
@@ -241,7 +401,7 @@ func (s *Db) loadParents(events <-chan *internal.EventInfo) {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := s.drv.Session(neo4j.AccessModeWrite)
+	session, err := s.session(neo4j.AccessModeWrite)
 	if err != nil {
 		panic(err)
 	}
@@ -258,23 +418,22 @@ func (s *Db) loadParents(events <-chan *internal.EventInfo) {
 	for info := range events {
 		event := info.Event
 		id := event.ID()
-		_, err = session.WriteTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
-			defer ctx.Close()
-
+		err = commitTx(session, func(ctx neo4j.Transaction) error {
 			for _, p := range event.Parents() {
-				pid := eventId2str(p)
-				err = exec(ctx, `MATCH (e:Event %s), (p:Event %s) CREATE (e)-[:PARENT]->(p)`,
-					fields{"id": eventId2str(id)},
+				pid := s.ids.str(p)
+				err := exec(ctx, `MATCH (e:Event %s), (p:Event %s) %s (e)-[:PARENT]->(p)`,
+					fields{"id": s.ids.str(id)},
 					fields{"id": pid},
+					s.edgeWrite,
 				)
 				if err != nil {
-					panic(err)
+					return err
 				}
 			}
-			return nil, ctx.Commit()
+			return nil
 		})
 		if err != nil {
-			ignoreFakeError(err)
+			s.deadLetter(info, err)
 		}
 
 		s.cache.EventInfos.Add(id, info)
@@ -297,49 +456,247 @@ func (s *Db) loadParents(events <-chan *internal.EventInfo) {
 		"last", last,
 		"rate", total*1000/time.Since(start).Milliseconds(),
 		"total", total,
+		"dead", atomic.LoadInt64(&s.deadLetters),
 		"elapsed", common.PrettyDuration(time.Since(start)))
 }
 
-// FindAncestors of event.
-func (s *Db) FindAncestors(e hash.Event) []hash.Event {
+// LoadParallel is a Load which writes events by concurrent workers.
+// Event is written with its PARENT edges only after all its parents are written.
+func (s *Db) LoadParallel(events <-chan *internal.EventInfo, workers int) {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := s.drv.Session(neo4j.AccessModeRead)
-	if err != nil {
-		panic(err)
+	var (
+		sched  = newScheduler(s.HasEvent)
+		blocks = newBlockTracker(s.GetLastBlock())
+		wg     sync.WaitGroup
+
+		start = time.Now().Add(-10 * time.Millisecond)
+		total int64
+	)
+
+	// last block is written by one goroutine, so workers don't wait for each other
+	changed := make(chan struct{}, 1)
+	marked := make(chan struct{})
+	go func() {
+		defer close(marked)
+		written := blocks.Safe()
+		for range changed {
+			if b := blocks.Safe(); written < b {
+				written = b
+				s.setLastBlock(b)
+			}
+		}
+		if b := blocks.Safe(); written < b {
+			s.setLastBlock(b)
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			session, err := s.session(neo4j.AccessModeWrite)
+			if err != nil {
+				panic(err)
+			}
+			defer session.Close()
+
+			for {
+				info, ok := sched.Next()
+				if !ok {
+					return
+				}
+
+				s.writeEvent(session, info)
+				id := info.Event.ID()
+				sched.Commit(id)
+				blocks.Commit(info.Block)
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+				s.cache.EventInfos.Add(id, info)
+				info.Done()
+
+				if n := atomic.AddInt64(&total, 1); n%1000 == 0 {
+					s.Log.Info("<<<", "last", id, "total", n, "elapsed", common.PrettyDuration(time.Since(start)))
+				}
+			}
+		}()
 	}
-	defer session.Close()
 
-	id := eventId2str(e)
+	for info := range events {
+		s.throttle.Wait()
+		blocks.Push(info.Block)
+		sched.Push(info)
+	}
+	sched.Close()
+	wg.Wait()
+	close(changed)
+	<-marked
+
+	s.Log.Info("Total imported events",
+		"rate", total*1000/time.Since(start).Milliseconds(),
+		"total", total,
+		"dead", atomic.LoadInt64(&s.deadLetters),
+		"elapsed", common.PrettyDuration(time.Since(start)))
+}
 
-	res, err := session.ReadTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
-		cursor, err := search(ctx, "MATCH (p:Event %s)-[:PARENT*]->(s:Event) RETURN DISTINCT s.id", fields{
-			"id": id,
-		})
+// writeEvent creates event node and its PARENT edges in one transaction.
+func (s *Db) writeEvent(session neo4j.Session, info *internal.EventInfo) {
+	span := s.span("Load")
+	span.SetAttribute("event", info.Event.ID().String())
+	err := commitTx(session, func(ctx neo4j.Transaction) error {
+		id := s.ids.str(info.Event.ID())
+		data := s.marshal(info)
+		s.Log.Debug("<<< event", "id", id, "data", data)
+		err := exec(ctx, "CREATE (e%s %s) SET e.imported_at = timestamp()", s.eventLabels(info.Event), data)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
-		var ancestors []hash.Event
-		for cursor.Next() {
-			pid := str2eventId(cursor.Record().GetByIndex(0).(string))
-			ancestors = append(ancestors, pid)
+		for _, p := range info.Event.Parents() {
+			err = exec(ctx, `MATCH (e:Event %s), (p:Event %s) %s (e)-[:PARENT]->(p)`,
+				fields{"id": id},
+				fields{"id": s.ids.str(p)},
+				s.edgeWrite,
+			)
+			if err != nil {
+				return err
+			}
 		}
-		return ancestors, nil
+		return nil
+	})
+	span.End(err)
+	if err != nil {
+		s.deadLetter(info, err)
+	}
+}
+
+// commitTx runs work in a write transaction of session and commits it,
+// returns the error of work or of the commit after the driver retries.
+func commitTx(session neo4j.Session, work func(neo4j.Transaction) error) error {
+	var committed bool
+	_, err := session.WriteTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
+		defer ctx.Close()
+
+		if err := work(ctx); err != nil {
+			return nil, err
+		}
+		err := ctx.Commit()
+		committed = err == nil
+		return nil, err
+	})
+	if committed {
+		// the driver commits the committed transaction once more and fails,
+		// the error is known, so it isn't counted as swallowed
+		if err != nil {
+			log.Trace("neo4j non critical error", "err", err)
+		}
+		return nil
+	}
+	return err
+}
+
+// deadLetter passes the event which is not written to Options.DeadLetter.
+func (s *Db) deadLetter(info *internal.EventInfo, err error) {
+	atomic.AddInt64(&s.deadLetters, 1)
+	s.Log.Error("event is not written", "id", info.Event.ID(), "err", err)
+	if s.onDeadLetter != nil {
+		s.onDeadLetter(info, err)
+	}
+}
+
+// FindAncestors of event.
+// It returns TraversalTooLargeError if there are more than Options.MaxTraversalResults ancestors.
+func (s *Db) FindAncestors(e hash.Event) (ancestors []hash.Event, err error) {
+	span := s.span("FindAncestors")
+	span.SetAttribute("event", e.String())
+	defer func() {
+		span.SetAttribute("results", len(ancestors))
+		span.End(err)
+	}()
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return s.searchEventIdsLimited(ctx, "MATCH (p:Event %s)-[:PARENT*]->(s:Event) RETURN DISTINCT s.id", fields{
+			"id": s.ids.str(e),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// FindAncestorsMulti returns the union of ancestors of starts by one query.
+// A start event is there only if it is an ancestor of another start.
+// It returns TraversalTooLargeError if there are more than Options.MaxTraversalResults ancestors.
+func (s *Db) FindAncestorsMulti(starts []hash.Event) ([]hash.Event, error) {
+	if len(starts) == 0 {
+		return nil, nil
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return s.searchEventIdsLimited(ctx, "UNWIND %s AS sid MATCH (p:Event {id: sid})-[:PARENT*]->(s:Event) RETURN DISTINCT s.id",
+			valToString(s.ids.strs(starts)),
+		)
 	})
 	if err != nil {
-		ignoreFakeError(err)
+		return nil, err
 	}
 
-	return res.([]hash.Event)
+	return res.(hash.Events), nil
+}
+
+// StreamAncestors calls fn for each ancestor of event as it is read from db,
+// so the whole ancestors set is never held in memory.
+// Error from fn aborts the traversal and is returned.
+// The query is not retried, so fn is called once per ancestor.
+func (s *Db) StreamAncestors(e hash.Event, fn func(hash.Event) error) error {
+	return s.streamEventIds(fn, "MATCH (p:Event %s)-[:PARENT*]->(s:Event) RETURN DISTINCT s.id", fields{
+		"id": s.ids.str(e),
+	})
+}
+
+// StreamDescendants calls fn for each descendant of event as it is read from db,
+// it is StreamAncestors in the other direction.
+func (s *Db) StreamDescendants(e hash.Event, fn func(hash.Event) error) error {
+	return s.streamEventIds(fn, "MATCH (p:Event %s)<-[:PARENT*]-(s:Event) RETURN DISTINCT s.id", fields{
+		"id": s.ids.str(e),
+	})
+}
+
+// streamEventIds calls fn for event id from the first column of each record,
+// the query is not retried.
+func (s *Db) streamEventIds(fn func(hash.Event) error, cypher string, a ...interface{}) error {
+	return s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, cypher, a...)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			id, err := readEventId(cursor.Record().GetByIndex(0))
+			if err != nil {
+				return err
+			}
+			err = fn(id)
+			if err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	})
 }
 
 func (s *Db) setLastBlock(num idx.Block) {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := s.drv.Session(neo4j.AccessModeWrite)
+	session, err := s.session(neo4j.AccessModeWrite)
 	if err != nil {
 		panic(err)
 	}
@@ -348,16 +705,29 @@ func (s *Db) setLastBlock(num idx.Block) {
 	_, err = session.WriteTransaction(func(ctx neo4j.Transaction) (interface{}, error) {
 		defer ctx.Close()
 
-		err := exec(ctx, `MATCH (s:State %s) SET s.block = %d`,
+		cursor, err := search(ctx, `MATCH (s:State %s) SET s.block = %d`,
 			fields{"id": "last"}, num)
 		if err != nil {
 			panic(err)
 		}
+		summary, err := cursor.Consume()
+		if err != nil {
+			panic(err)
+		}
+		if summary.Counters().PropertiesSet() == 0 {
+			// State node creation in New is not checked, so make sure it exists
+			s.Log.Warn("last block state is missing, create it", "block", num)
+			err = exec(ctx, `MERGE (s:State %s) SET s.block = %d`,
+				fields{"id": "last"}, num)
+			if err != nil {
+				panic(err)
+			}
+		}
 
 		return nil, ctx.Commit()
 	})
 	if err != nil {
-		ignoreFakeError(err)
+		s.ignoreFakeError(err)
 	}
 }
 
@@ -365,7 +735,7 @@ func (s *Db) GetLastBlock() idx.Block {
 	s.busy.Add(1)
 	defer s.busy.Done()
 
-	session, err := s.drv.Session(neo4j.AccessModeRead)
+	session, err := s.session(neo4j.AccessModeRead)
 	if err != nil {
 		panic(err)
 	}
@@ -386,7 +756,7 @@ func (s *Db) GetLastBlock() idx.Block {
 		return nil, nil
 	})
 	if err != nil {
-		ignoreFakeError(err)
+		s.ignoreFakeError(err)
 	}
 	if res == nil {
 		return idx.Block(2)
@@ -394,6 +764,78 @@ func (s *Db) GetLastBlock() idx.Block {
 	return res.(idx.Block)
 }
 
+// CompareAndSetLastBlock sets the last block to new only if it is expected,
+// so concurrent importers don't overwrite each other's progress. It returns whether the block is set.
+func (s *Db) CompareAndSetLastBlock(expected, new idx.Block) (bool, error) {
+	res, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		// the dummy write locks the node before the block is compared, so the comparison sees the latest value
+		cursor, err := search(ctx, `MATCH (s:State %s)
+			SET s.lock = true REMOVE s.lock
+			WITH s WHERE s.block = %d
+			SET s.block = %d RETURN s.block`,
+			fields{"id": "last"}, int64(expected), int64(new))
+		if err != nil {
+			return nil, err
+		}
+		swapped := cursor.Next()
+		return swapped, cursor.Err()
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return res.(bool), nil
+}
+
+// writeTx runs work in a write transaction of a new session.
+func (s *Db) writeTx(work neo4j.TransactionWork) (interface{}, error) {
+	s.busy.Add(1)
+	defer s.busy.Done()
+
+	session, err := s.session(neo4j.AccessModeWrite)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return session.WriteTransaction(work)
+}
+
+// readTx runs work in a read transaction of a new session.
+func (s *Db) readTx(work neo4j.TransactionWork) (interface{}, error) {
+	s.busy.Add(1)
+	defer s.busy.Done()
+
+	session, err := s.session(neo4j.AccessModeRead)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return session.ReadTransaction(work)
+}
+
+// readOnce runs work in an explicit read transaction of a new session.
+// Unlike readTx the work is never retried by driver, so it suits streaming to callbacks.
+func (s *Db) readOnce(work func(neo4j.Transaction) error) error {
+	s.busy.Add(1)
+	defer s.busy.Done()
+
+	session, err := s.session(neo4j.AccessModeRead)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	tx, err := session.BeginTransaction()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+
+	return work(tx)
+}
+
 func exec(ctx neo4j.Transaction, cypher string, a ...interface{}) error {
 	query := fmt.Sprintf(cypher, a...)
 	log.Debug("cypher", "query", query)
@@ -416,6 +858,81 @@ func search(ctx neo4j.Transaction, cypher string, a ...interface{}) (neo4j.Resul
 	return res, nil
 }
 
-func ignoreFakeError(err error) {
+// readEventIds reads event ids from the first column of all the records.
+func readEventIds(cursor neo4j.Result) (hash.Events, error) {
+	var ids hash.Events
+	for cursor.Next() {
+		id, err := readEventId(cursor.Record().GetByIndex(0))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, cursor.Err()
+}
+
+// searchEventIdsLimited reads event ids of traversal query limited by Options.MaxTraversalResults.
+func (s *Db) searchEventIdsLimited(ctx neo4j.Transaction, cypher string, a ...interface{}) (hash.Events, error) {
+	if s.maxTraversal > 0 {
+		// one more to know the limit is exceeded
+		cypher += fmt.Sprintf(" LIMIT %d", s.maxTraversal+1)
+	}
+	cursor, err := search(ctx, cypher, a...)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := readEventIds(cursor)
+	if err != nil {
+		return nil, err
+	}
+	if s.maxTraversal > 0 && len(ids) > s.maxTraversal {
+		return nil, &TraversalTooLargeError{Partial: s.maxTraversal}
+	}
+	return ids, nil
+}
+
+// ignoreFakeError counts the error which is not returned by code, see SwallowedErrorStats.
+func (s *Db) ignoreFakeError(err error) {
 	log.Trace("neo4j non critical error", "err", err)
+
+	code := errorCode(err)
+	s.swallowed.Lock()
+	defer s.swallowed.Unlock()
+	if s.swallowed.counts == nil {
+		s.swallowed.counts = make(map[string]int64)
+	}
+	s.swallowed.counts[code]++
+}
+
+// SwallowedErrorStats returns the numbers of errors which are logged only, by the error code.
+// Server errors are counted by their Neo4j code, the driver errors by their kind.
+func (s *Db) SwallowedErrorStats() map[string]int64 {
+	s.swallowed.Lock()
+	defer s.swallowed.Unlock()
+
+	stats := make(map[string]int64, len(s.swallowed.counts))
+	for code, n := range s.swallowed.counts {
+		stats[code] = n
+	}
+	return stats
+}
+
+// errorCode returns the Neo4j code of server error, e.g. "Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists",
+// or the kind of driver error.
+func errorCode(err error) string {
+	const serverError = "Server error: ["
+	if msg := err.Error(); strings.HasPrefix(msg, serverError) {
+		if end := strings.IndexByte(msg, ']'); end > 0 {
+			return msg[len(serverError):end]
+		}
+	}
+	switch {
+	case neo4j.IsServiceUnavailable(err):
+		return "ServiceUnavailable"
+	case neo4j.IsSecurityError(err):
+		return "SecurityError"
+	default:
+		return fmt.Sprintf("%T", err)
+	}
 }
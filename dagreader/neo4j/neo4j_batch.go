@@ -0,0 +1,123 @@
+package neo4j
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// defaultLoadBatchDelay is a longest time Load batch waits to be filled by default.
+const defaultLoadBatchDelay = 100 * time.Millisecond
+
+// batchLimits trigger the Load batch flush, whichever is reached first.
+type batchLimits struct {
+	events int
+	bytes  int
+	delay  time.Duration
+}
+
+// eventsBatch is the events written by Load in one transaction.
+type eventsBatch struct {
+	limits batchLimits
+	infos  []*internal.EventInfo
+	data   []string
+	bytes  int
+}
+
+func newEventsBatch(limits batchLimits) *eventsBatch {
+	return &eventsBatch{
+		limits: limits,
+	}
+}
+
+// add event with its marshaled data to the batch and reports if the batch should be flushed.
+// The data length estimates the event size in the transaction.
+func (b *eventsBatch) add(info *internal.EventInfo, data string) (full bool) {
+	b.infos = append(b.infos, info)
+	b.data = append(b.data, data)
+	b.bytes += len(data)
+
+	return len(b.infos) >= b.limits.events ||
+		(b.limits.bytes > 0 && b.bytes >= b.limits.bytes)
+}
+
+// Len, Less and Swap sort batch by epoch, lamport and id,
+// so the batch is written in the same order regardless of the events arrival order.
+func (b *eventsBatch) Len() int {
+	return len(b.infos)
+}
+
+func (b *eventsBatch) Less(i, j int) bool {
+	a, c := b.infos[i].Event, b.infos[j].Event
+	if a.Epoch() != c.Epoch() {
+		return a.Epoch() < c.Epoch()
+	}
+	if a.Lamport() != c.Lamport() {
+		return a.Lamport() < c.Lamport()
+	}
+	return bytes.Compare(a.ID().Bytes(), c.ID().Bytes()) < 0
+}
+
+func (b *eventsBatch) Swap(i, j int) {
+	b.infos[i], b.infos[j] = b.infos[j], b.infos[i]
+	b.data[i], b.data[j] = b.data[j], b.data[i]
+}
+
+func (b *eventsBatch) empty() bool {
+	return len(b.infos) == 0
+}
+
+func (b *eventsBatch) reset() {
+	b.infos = b.infos[:0]
+	b.data = b.data[:0]
+	b.bytes = 0
+}
+
+// eventsLoader streams events into Load of a Db, it is for the finite inputs like files.
+type eventsLoader struct {
+	db      *Db
+	input   chan *internal.EventInfo
+	loaded  chan struct{}
+	written sync.WaitGroup
+	dead    int64
+}
+
+func (s *Db) newEventsLoader() *eventsLoader {
+	l := &eventsLoader{
+		db:     s,
+		input:  make(chan *internal.EventInfo, s.batch.events),
+		loaded: make(chan struct{}),
+		dead:   atomic.LoadInt64(&s.deadLetters),
+	}
+	go func() {
+		defer close(l.loaded)
+		s.Load(l.input)
+	}()
+	return l
+}
+
+// add event unless it is in db already. Events have to be added parents first.
+func (l *eventsLoader) add(info *internal.EventInfo) {
+	if l.db.HasEvent(info.Event.ID()) {
+		return
+	}
+	l.written.Add(1)
+	info.Dispose = l.written.Done
+	l.input <- info
+}
+
+// close waits until the added events are written and returns error if some of them are not.
+func (l *eventsLoader) close() error {
+	close(l.input)
+	l.written.Wait()
+	<-l.loaded
+
+	if lost := atomic.LoadInt64(&l.db.deadLetters) - l.dead; lost > 0 {
+		return fmt.Errorf("%d events are not written", lost)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package neo4j
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// BenchResult is a Load throughput measurement.
+type BenchResult struct {
+	Events     int
+	Total      time.Duration
+	PerSecond  float64
+	LatencyP50 time.Duration
+	LatencyP99 time.Duration
+}
+
+// Benchmark feeds events through Load and measures the throughput and per-event latency
+// (time from event is sent to Load until it is written with its parents).
+func Benchmark(db *Db, events []*inter.Event) *BenchResult {
+	var (
+		input     = make(chan *internal.EventInfo, 10)
+		latencies = make([]time.Duration, len(events))
+		written   sync.WaitGroup
+	)
+
+	start := time.Now()
+	go db.Load(input)
+
+	// Load returns before the PARENT edges are written, so wait until each event is disposed
+	written.Add(len(events))
+	for i, e := range events {
+		i := i
+		sent := time.Now()
+		input <- &internal.EventInfo{
+			Block: 1,
+			Event: e,
+			Dispose: func() {
+				latencies[i] = time.Since(sent)
+				written.Done()
+			},
+		}
+	}
+	close(input)
+	written.Wait()
+
+	res := &BenchResult{
+		Events: len(events),
+		Total:  time.Since(start),
+	}
+	res.PerSecond = float64(res.Events) / res.Total.Seconds()
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		res.LatencyP50 = latencies[len(latencies)*50/100]
+		res.LatencyP99 = latencies[len(latencies)*99/100]
+	}
+
+	return res
+}
+
+// SyntheticDAG generates count events of epoch by validators,
+// each event refers to its self-parent and to a few earlier events of other validators.
+func SyntheticDAG(epoch idx.Epoch, validators, count int, seed int64) []*inter.Event {
+	r := rand.New(rand.NewSource(seed))
+
+	var (
+		events = make([]*inter.Event, 0, count)
+		last   = make([]*inter.Event, validators)
+	)
+	for len(events) < count {
+		creator := r.Intn(validators)
+		self := last[creator]
+
+		e := &inter.MutableEventPayload{}
+		e.SetEpoch(epoch)
+		e.SetCreator(idx.ValidatorID(creator + 1))
+		e.SetCreationTime(inter.Timestamp(len(events) + 1))
+		e.SetMedianTime(inter.Timestamp(len(events) + 1))
+
+		var (
+			parents hash.Events
+			lamport idx.Lamport
+			seq     idx.Event = 1
+		)
+		if self != nil {
+			parents = append(parents, self.ID())
+			lamport = self.Lamport()
+			seq = self.Seq() + 1
+		}
+		for _, i := range r.Perm(validators)[:r.Intn(validators)] {
+			p := last[i]
+			if p == nil || i == creator {
+				continue
+			}
+			parents = append(parents, p.ID())
+			if lamport < p.Lamport() {
+				lamport = p.Lamport()
+			}
+		}
+		e.SetParents(parents)
+		e.SetLamport(lamport + 1)
+		e.SetSeq(seq)
+
+		event := &e.Build().Event
+		events = append(events, event)
+		last[creator] = event
+	}
+
+	return events
+}
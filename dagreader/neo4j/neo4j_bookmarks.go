@@ -0,0 +1,60 @@
+package neo4j
+
+import (
+	"sync"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// bookmarksLimit is a number of the recent write bookmarks the reads wait for.
+// Concurrent writers commit in any order, so a few ones are kept instead of the last one.
+const bookmarksLimit = 32
+
+// bookmarks are the recent writes of Db, the causally consistent reads observe them.
+type bookmarks struct {
+	recent []string
+	sync.Mutex
+}
+
+func (b *bookmarks) add(bookmark string) {
+	if bookmark == "" {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+
+	for _, known := range b.recent {
+		if known == bookmark {
+			return
+		}
+	}
+	b.recent = append(b.recent, bookmark)
+	if len(b.recent) > bookmarksLimit {
+		b.recent = b.recent[len(b.recent)-bookmarksLimit:]
+	}
+}
+
+func (b *bookmarks) get() []string {
+	b.Lock()
+	defer b.Unlock()
+
+	return append([]string(nil), b.recent...)
+}
+
+// bookmarkedSession records bookmarks of its writes.
+type bookmarkedSession struct {
+	neo4j.Session
+	bookmarks *bookmarks
+}
+
+func (s *bookmarkedSession) WriteTransaction(work neo4j.TransactionWork, configurers ...func(*neo4j.TransactionConfig)) (interface{}, error) {
+	res, err := s.Session.WriteTransaction(work, configurers...)
+	s.bookmarks.add(s.LastBookmark())
+	return res, err
+}
+
+func (s *bookmarkedSession) Close() error {
+	// auto-commit transactions are completed by now
+	s.bookmarks.add(s.LastBookmark())
+	return s.Session.Close()
+}
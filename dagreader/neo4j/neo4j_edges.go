@@ -0,0 +1,83 @@
+package neo4j
+
+import (
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// edgesBatch is a number of edges written in one transaction.
+const edgesBatch = 100
+
+// LoadEdges merges PARENT edges of {child, parent} pairs, e.g. to repair edges lost during import.
+// Pairs with child or parent not in db are skipped and logged.
+// It reads edges until the channel is closed, also after the write error, so the producer is never blocked.
+func (s *Db) LoadEdges(edges <-chan [2]hash.Event) error {
+	var (
+		batch   = make([][2]hash.Event, 0, edgesBatch)
+		written int
+		skipped int
+	)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := s.mergeEdges(batch)
+		if err != nil {
+			return err
+		}
+		written += n
+		skipped += len(batch) - n
+		batch = batch[:0]
+		return nil
+	}
+
+	for edge := range edges {
+		batch = append(batch, edge)
+		if len(batch) >= edgesBatch {
+			if err := flush(); err != nil {
+				for range edges {
+				}
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		s.Log.Warn("edges of absent events are skipped", "count", skipped)
+	}
+	s.Log.Info("edges are loaded", "count", written)
+	return nil
+}
+
+// mergeEdges returns the number of edges which both events exist.
+func (s *Db) mergeEdges(edges [][2]hash.Event) (int, error) {
+	res, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		var written int
+		for _, edge := range edges {
+			cursor, err := search(ctx, `MATCH (e:Event %s), (p:Event %s) MERGE (e)-[:PARENT]->(p) RETURN e.id`,
+				fields{"id": s.ids.str(edge[0])},
+				fields{"id": s.ids.str(edge[1])},
+			)
+			if err != nil {
+				return nil, err
+			}
+			if cursor.Next() {
+				written++
+			} else {
+				s.Log.Debug("edge is skipped", "child", edge[0], "parent", edge[1])
+			}
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+		}
+		return written, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.(int), nil
+}
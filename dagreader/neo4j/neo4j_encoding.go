@@ -1,14 +1,22 @@
 package neo4j
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 
 	"github.com/Fantom-foundation/go-opera/inter"
 	"github.com/Fantom-foundation/lachesis-base/hash"
 	"github.com/Fantom-foundation/lachesis-base/inter/idx"
-	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/neo4j/neo4j-go-driver/neo4j"
 
 	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
@@ -16,6 +24,19 @@ import (
 
 type fields map[string]interface{}
 
+// marshal event with the Db id encoding.
+func (s *Db) marshal(info *internal.EventInfo) fields {
+	ff := marshal(info)
+	if s.ids != HexIds {
+		ff["id"] = s.ids.str(info.Event.ID())
+		ff["parents"] = s.ids.strs(info.Event.Parents())
+	}
+	if s.compressPayload {
+		compressPayload(ff)
+	}
+	return ff
+}
+
 func readFields(r neo4j.Record) fields {
 	ff := make(fields)
 	vals := r.Values()
@@ -57,33 +78,141 @@ func valToString(v interface{}) string {
 func marshal(x interface{}) fields {
 	switch v := x.(type) {
 	case *internal.EventInfo:
-		return fields{
+		e := v.Event
+		ff := fields{
 			"block":   int64(v.Block),
 			"role":    v.Role,
-			"id":      eventId2str(v.Event.ID()),
-			"creator": int64(v.Event.Creator()),
-			"parents": v.Event.Parents(),
+			"id":      eventId2str(e.ID()),
+			"epoch":   int64(e.Epoch()),
+			"seq":     int64(e.Seq()),
+			"frame":   int64(e.Frame()),
+			"lamport": int64(e.Lamport()),
+			"creator": int64(e.Creator()),
+			"parents": eventIds2strs(e.Parents()),
+			// explicit false is indexed, unlike missing property
+			"finalized": false,
+		}
+		// opera specific fields, they are required to get the same event hash back
+		if ext, ok := e.(inter.EventI); ok {
+			gas := ext.GasPowerLeft().Gas
+			ff["creation_time"] = int64(ext.CreationTime())
+			ff["median_time"] = int64(ext.MedianTime())
+			ff["gas_power_used"] = int64(ext.GasPowerUsed())
+			ff["gas_power_left"] = []int64{int64(gas[0]), int64(gas[1])}
+			ff["tx_hash"] = ext.TxHash().Hex()
+			ff["extra"] = hexutil.Encode(ext.Extra())
+			if h := ext.PrevEpochHash(); h != nil {
+				ff["prev_epoch_hash"] = h.Hex()
+			}
 		}
+		// transactions are known only if event came with payload
+		if p, ok := e.(inter.EventPayloadI); ok && len(p.Txs()) > 0 {
+			ff["payload"] = encodePayload(p.Txs())
+		}
+		return ff
 	default:
 		panic("unsupported type")
 	}
 }
 
+// encodePayload is a base64 of the transactions RLP.
+func encodePayload(txs types.Transactions) string {
+	bb, err := rlp.EncodeToBytes(txs)
+	if err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(bb)
+}
+
+// compressPayload replaces the payload with a base64 of the gzipped RLP and marks it by payload_gzip.
+func compressPayload(ff fields) {
+	payload, ok := ff["payload"].(string)
+	if !ok {
+		return
+	}
+	bb, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		panic(err)
+	}
+
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err = w.Write(bb); err != nil {
+		panic(err)
+	}
+	if err = w.Close(); err != nil {
+		panic(err)
+	}
+	ff["payload"] = base64.StdEncoding.EncodeToString(buf.Bytes())
+	ff["payload_gzip"] = true
+}
+
+// decodePayload returns the transactions RLP of the stored payload.
+func decodePayload(payload string, compressed bool) ([]byte, error) {
+	bb, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || !compressed {
+		return bb, err
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(bb))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
 func unmarshal(ff fields, x interface{}) {
 	switch v := x.(type) {
 	case *internal.EventInfo:
-		v.Block = idx.Block(ff["block"].(int64))
+		v.Block = idx.Block(toInt64(ff["block"]))
 		v.Role = ff["role"].(string)
 
 		event := &inter.MutableEventPayload{}
-		id := str2eventId(ff["id"].(string))
+		id := mustEventId(ff["id"].(string))
 		event.SetEpoch(id.Epoch())
 		event.SetLamport(id.Lamport())
 		event.SetID(eventIdTail(id))
 
-		event.SetCreator(idx.ValidatorID(ff["creator"].(int64)))
+		event.SetCreator(idx.ValidatorID(toInt64(ff["creator"])))
+		if seq, ok := ff["seq"]; ok {
+			event.SetSeq(idx.Event(toInt64(seq)))
+		}
+		if frame, ok := ff["frame"]; ok {
+			event.SetFrame(idx.Frame(toInt64(frame)))
+		}
+
+		if t, ok := ff["creation_time"]; ok {
+			event.SetCreationTime(inter.Timestamp(toInt64(t)))
+		}
+		if t, ok := ff["median_time"]; ok {
+			event.SetMedianTime(inter.Timestamp(toInt64(t)))
+		}
+		if gas, ok := ff["gas_power_used"]; ok {
+			event.SetGasPowerUsed(uint64(toInt64(gas)))
+		}
+		if gas, ok := ff["gas_power_left"]; ok {
+			left := inter.GasPowerLeft{}
+			for i, g := range toInt64s(gas) {
+				left.Gas[i] = uint64(g)
+			}
+			event.SetGasPowerLeft(left)
+		}
+		if h, ok := ff["tx_hash"]; ok {
+			event.SetTxHash(hash.HexToHash(h.(string)))
+		}
+		if extra, ok := ff["extra"]; ok {
+			bb := hexutil.MustDecode(extra.(string))
+			if len(bb) > 0 {
+				event.SetExtra(bb)
+			}
+		}
+		if h, ok := ff["prev_epoch_hash"]; ok {
+			prev := hash.HexToHash(h.(string))
+			event.SetPrevEpochHash(&prev)
+		}
 
-		event.SetParents(ff["parents"].(hash.Events))
+		event.SetParents(toEventIds(ff["parents"]))
 
 		v.Event = &event.Build().Event
 		return
@@ -92,28 +221,177 @@ func unmarshal(ff fields, x interface{}) {
 	}
 }
 
+// toInt64 converts numeric value from marshal or from Neo4j driver.
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	default:
+		panic("unsupported numeric type")
+	}
+}
+
+// toInt64s converts list value from marshal or from Neo4j driver.
+func toInt64s(v interface{}) []int64 {
+	switch vv := v.(type) {
+	case []int64:
+		return vv
+	case []interface{}:
+		nn := make([]int64, len(vv))
+		for i, n := range vv {
+			nn[i] = toInt64(n)
+		}
+		return nn
+	default:
+		panic("unsupported list type")
+	}
+}
+
+// toEventIds converts parents value from marshal, from Neo4j driver or from readParents.
+func toEventIds(v interface{}) hash.Events {
+	switch vv := v.(type) {
+	case nil:
+		return nil
+	case hash.Events:
+		return vv
+	case []string:
+		return strs2eventIds(vv)
+	case []interface{}:
+		if len(vv) == 0 {
+			return nil
+		}
+		ids := make(hash.Events, len(vv))
+		for i, s := range vv {
+			ids[i] = mustEventId(s.(string))
+		}
+		return ids
+	default:
+		panic("unsupported parents type")
+	}
+}
+
 func eventId2str(e hash.Event) string {
 	return e.FullID()
 }
 
-// TODO: mv to the "github.com/Fantom-foundation/lachesis-base/hash"
-func str2eventId(s string) (id hash.Event) {
+// IdEncoding is a format of the stored event id: "epoch:lamport:" followed by the rest of hash.
+type IdEncoding int
+
+const (
+	// HexIds encodes the rest of hash as hex (the hash.Event.FullID format).
+	HexIds IdEncoding = iota
+	// Base64Ids encodes the rest of hash as base64, so ids are 16 bytes shorter.
+	Base64Ids
+)
+
+func (enc IdEncoding) str(e hash.Event) string {
+	if enc == Base64Ids {
+		tail := eventIdTail(e)
+		return fmt.Sprintf("%d:%d:%s", e.Epoch(), e.Lamport(), base64.RawURLEncoding.EncodeToString(tail[:]))
+	}
+	return eventId2str(e)
+}
+
+func (enc IdEncoding) strs(ee hash.Events) []string {
+	ss := make([]string, len(ee))
+	for i, e := range ee {
+		ss[i] = enc.str(e)
+	}
+	return ss
+}
+
+// idEncodingOf returns encoding of the stored id.
+func idEncodingOf(s string) IdEncoding {
 	parts := strings.SplitN(s, ":", 3)
+	if len(parts) == 3 && len(parts[2]) == base64.RawURLEncoding.EncodedLen(32-4-4) {
+		return Base64Ids
+	}
+	return HexIds
+}
+
+func eventIds2strs(ee hash.Events) []string {
+	ss := make([]string, len(ee))
+	for i, e := range ee {
+		ss[i] = eventId2str(e)
+	}
+	return ss
+}
+
+func strs2eventIds(ss []string) hash.Events {
+	if len(ss) == 0 {
+		return nil
+	}
+	ee := make(hash.Events, len(ss))
+	for i, s := range ss {
+		ee[i] = mustEventId(s)
+	}
+	return ee
+}
+
+// str2eventId parses the stored event id of any IdEncoding.
+// Malformed id is an error, so a corrupted node doesn't crash the query.
+func str2eventId(s string) (id hash.Event, err error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return id, fmt.Errorf("malformed event id %q", s)
+	}
 
 	n, err := strconv.ParseUint(parts[0], 10, 32)
 	if err != nil {
-		panic(err)
+		return id, fmt.Errorf("malformed event id %q epoch: %w", s, err)
 	}
 	copy(id[0:], idx.Epoch(n).Bytes())
 
 	n, err = strconv.ParseUint(parts[1], 10, 32)
 	if err != nil {
-		panic(err)
+		return id, fmt.Errorf("malformed event id %q lamport: %w", s, err)
 	}
 	copy(id[4:], idx.Lamport(n).Bytes())
 
-	copy(id[8:], common.Hex2BytesFixed(parts[2], 32-4-4))
+	var tail []byte
+	if idEncodingOf(s) == Base64Ids {
+		tail, err = base64.RawURLEncoding.DecodeString(parts[2])
+	} else {
+		tail, err = hex.DecodeString(parts[2])
+	}
+	if err != nil {
+		return id, fmt.Errorf("malformed event id %q: %w", s, err)
+	}
+	if len(tail) != len(id)-8 {
+		return id, fmt.Errorf("malformed event id %q: %d bytes", s, len(tail)+8)
+	}
+	copy(id[8:], tail)
+
+	return id, nil
+}
 
+// mustEventId is a str2eventId for the values which have no error path, e.g. unmarshal.
+func mustEventId(s string) hash.Event {
+	id, err := str2eventId(s)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+// readEventId reads event id from the query result value.
+func readEventId(v interface{}) (hash.Event, error) {
+	s, ok := v.(string)
+	if !ok {
+		return hash.Event{}, fmt.Errorf("event id is %T", v)
+	}
+	return str2eventId(s)
+}
+
+// readEdgePair reads child and parent ids from the first two columns of the record.
+func readEdgePair(rec neo4j.Record) (edge EdgePair, err error) {
+	edge.Child, err = readEventId(rec.GetByIndex(0))
+	if err != nil {
+		return
+	}
+	edge.Parent, err = readEventId(rec.GetByIndex(1))
 	return
 }
 
@@ -0,0 +1,110 @@
+package neo4j
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// planNode is a common part of neo4j.Plan and neo4j.ProfiledPlan.
+type planNode struct {
+	Operator    string
+	Identifiers []string
+	Arguments   map[string]interface{}
+	Profiled    bool
+	DbHits      int64
+	Records     int64
+	Children    []*planNode
+}
+
+// Explain returns the query plan of cypher, e.g. to check an index is used.
+// With profile the query is executed (and rolled back) to get the actual db hits and rows.
+func (s *Db) Explain(cypher string, params map[string]interface{}, profile bool) (string, error) {
+	plan, err := s.queryPlan(cypher, params, profile)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	plan.format(&out, 0)
+	return out.String(), nil
+}
+
+func (s *Db) queryPlan(cypher string, params map[string]interface{}, profile bool) (*planNode, error) {
+	prefix := "EXPLAIN "
+	if profile {
+		prefix = "PROFILE "
+	}
+
+	var plan *planNode
+	// the transaction is never committed, so PROFILE of a write query changes nothing
+	err := s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := ctx.Run(prefix+cypher, params)
+		if err != nil {
+			return err
+		}
+		summary, err := cursor.Consume()
+		if err != nil {
+			return err
+		}
+		if profile && summary.Profile() != nil {
+			plan = fromProfiledPlan(summary.Profile())
+		} else if summary.Plan() != nil {
+			plan = fromPlan(summary.Plan())
+		}
+		if plan == nil {
+			return fmt.Errorf("no query plan")
+		}
+		return nil
+	})
+
+	return plan, err
+}
+
+func fromPlan(p neo4j.Plan) *planNode {
+	n := &planNode{
+		Operator:    p.Operator(),
+		Identifiers: p.Identifiers(),
+		Arguments:   p.Arguments(),
+	}
+	for _, c := range p.Children() {
+		n.Children = append(n.Children, fromPlan(c))
+	}
+	return n
+}
+
+func fromProfiledPlan(p neo4j.ProfiledPlan) *planNode {
+	n := &planNode{
+		Operator:    p.Operator(),
+		Identifiers: p.Identifiers(),
+		Arguments:   p.Arguments(),
+		Profiled:    true,
+		DbHits:      p.DbHits(),
+		Records:     p.Records(),
+	}
+	for _, c := range p.Children() {
+		n.Children = append(n.Children, fromProfiledPlan(c))
+	}
+	return n
+}
+
+// format writes plan as an indented tree, one operator per line.
+func (n *planNode) format(out *strings.Builder, depth int) {
+	out.WriteString(strings.Repeat("  ", depth))
+	out.WriteString(n.Operator)
+	if len(n.Identifiers) > 0 {
+		fmt.Fprintf(out, " (%s)", strings.Join(n.Identifiers, ", "))
+	}
+	if n.Profiled {
+		fmt.Fprintf(out, " dbHits=%d rows=%d", n.DbHits, n.Records)
+	}
+	if details, ok := n.Arguments["Details"]; ok {
+		fmt.Fprintf(out, " %v", details)
+	}
+	out.WriteString("\n")
+
+	for _, c := range n.Children {
+		c.format(out, depth+1)
+	}
+}
@@ -0,0 +1,197 @@
+package neo4j
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// exportRecord is a line of JSONL export: either event properties or PARENT edge.
+type exportRecord struct {
+	Event  fields      `json:"event,omitempty"`
+	Parent *exportEdge `json:"parent,omitempty"`
+}
+
+type exportEdge struct {
+	Child  string `json:"child"`
+	Parent string `json:"parent"`
+}
+
+// ExportFilter selects the exported events, nil filter selects all.
+type ExportFilter func(*internal.EventInfo) bool
+
+// ExportCreatorSubgraph writes events of creator in epoch and PARENT edges among them as JSONL.
+// Only the events selected by filter and the edges between them are written.
+func (s *Db) ExportCreatorSubgraph(creator idx.ValidatorID, epoch idx.Epoch, w io.Writer, filter ExportFilter) error {
+	enc := json.NewEncoder(w)
+	match := fields{
+		"epoch":   int64(epoch),
+		"creator": int64(creator),
+	}
+	exported := make(map[string]struct{})
+
+	// written records can't be taken back, so the export is not retried
+	return s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e ORDER BY e.lamport, e.id`, match)
+		if err != nil {
+			return err
+		}
+		for cursor.Next() {
+			props := fields(cursor.Record().GetByIndex(0).(neo4j.Node).Props())
+			if filter != nil && !filter(readEvent(props)) {
+				continue
+			}
+			exported[props["id"].(string)] = struct{}{}
+			err = enc.Encode(exportRecord{Event: props})
+			if err != nil {
+				return err
+			}
+		}
+		if err = cursor.Err(); err != nil {
+			return err
+		}
+
+		cursor, err = search(ctx, `MATCH (e:Event %s)-[:PARENT]->(p:Event %s) RETURN e.id, p.id ORDER BY e.id, p.id`, match, match)
+		if err != nil {
+			return err
+		}
+		for cursor.Next() {
+			rec := cursor.Record()
+			edge := &exportEdge{
+				Child:  rec.GetByIndex(0).(string),
+				Parent: rec.GetByIndex(1).(string),
+			}
+			if !isExported(exported, edge) {
+				continue
+			}
+			err = enc.Encode(exportRecord{Parent: edge})
+			if err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	})
+}
+
+// exportLag is the age of the events exported by ExportSince. Load takes the import time before the commit,
+// so the events of the younger transactions may be still invisible while the later ones are committed.
+const exportLag = 30 * time.Second
+
+// ExportSince writes the events imported after since as JSONL event records ordered by import time,
+// and returns the import time of the last written one to pass next time (or since if there are none).
+// Import time has milliseconds precision, all the events of a millisecond are in the same export.
+// Only the events imported exportLag ago are exported, so the events committed later are not missed.
+// On error the returned time is of the last millisecond which events are written completely.
+// The events loaded before imported_at was introduced are never exported.
+func (s *Db) ExportSince(w io.Writer, since time.Time) (time.Time, error) {
+	var after int64
+	if !since.IsZero() {
+		after = since.UnixNano() / int64(time.Millisecond)
+	}
+	var (
+		done    = after
+		current = after
+		enc     = json.NewEncoder(w)
+	)
+
+	// written records can't be taken back, so the export is not retried
+	err := s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event) WHERE e.imported_at > %d AND e.imported_at <= timestamp() - %d
+			RETURN e ORDER BY e.imported_at, e.id`,
+			after,
+			int64(exportLag/time.Millisecond),
+		)
+		if err != nil {
+			return err
+		}
+		for cursor.Next() {
+			props := fields(cursor.Record().GetByIndex(0).(neo4j.Node).Props())
+			if at := toInt64(props["imported_at"]); at != current {
+				done, current = current, at
+			}
+			err = enc.Encode(exportRecord{Event: props})
+			if err != nil {
+				return err
+			}
+		}
+		if err = cursor.Err(); err != nil {
+			return err
+		}
+		done = current
+		return nil
+	})
+	if done == after {
+		return since, err
+	}
+
+	return time.Unix(done/1000, done%1000*int64(time.Millisecond)), err
+}
+
+func readEvent(ff fields) *internal.EventInfo {
+	info := new(internal.EventInfo)
+	unmarshal(ff, info)
+	return info
+}
+
+func isExported(exported map[string]struct{}, edge *exportEdge) bool {
+	_, child := exported[edge.Child]
+	_, parent := exported[edge.Parent]
+	return child && parent
+}
+
+// epochsExport is the db state besides the events.
+type epochsExport struct {
+	LastBlock     int64         `json:"last_block"`
+	SchemaVersion int64         `json:"schema_version"`
+	Epochs        []epochExport `json:"epochs"`
+}
+
+type epochExport struct {
+	Epoch    int64 `json:"epoch"`
+	Events   int64 `json:"events"`
+	MaxFrame int64 `json:"max_frame"`
+}
+
+// ExportEpochs writes the last block, schema version and the stored epochs summary as JSON.
+// There is no epoch state node, the epochs are summarized from their events. Export is read-only,
+// so db which is not marked with schema version yet is exported with 0.
+func (s *Db) ExportEpochs(w io.Writer) error {
+	version, err := s.readSchemaVersion()
+	if err != nil {
+		return err
+	}
+	export := epochsExport{
+		LastBlock:     int64(s.GetLastBlock()),
+		SchemaVersion: version,
+		Epochs:        []epochExport{},
+	}
+
+	_, err = s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event)
+			RETURN e.epoch AS epoch, count(e), max(e.frame) ORDER BY epoch`)
+		if err != nil {
+			return nil, err
+		}
+
+		export.Epochs = export.Epochs[:0]
+		for cursor.Next() {
+			rec := cursor.Record()
+			export.Epochs = append(export.Epochs, epochExport{
+				Epoch:    toInt64(rec.GetByIndex(0)),
+				Events:   toInt64(rec.GetByIndex(1)),
+				MaxFrame: toInt64(rec.GetByIndex(2)),
+			})
+		}
+		return nil, cursor.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(export)
+}
@@ -0,0 +1,47 @@
+package neo4j
+
+import (
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// MarkFinalized sets the finalized flag of event.
+func (s *Db) MarkFinalized(e hash.Event) error {
+	_, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) SET e.finalized = true RETURN e.id`, fields{
+			"id": s.ids.str(e),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		return nil, nil
+	})
+
+	return err
+}
+
+// GetUnfinalized returns events of epoch which are not marked as finalized.
+// Events imported before the finalized property was introduced are not returned.
+func (s *Db) GetUnfinalized(epoch idx.Epoch) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) WHERE e.finalized = false RETURN e.id ORDER BY e.id`, fields{
+			"epoch": int64(epoch),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
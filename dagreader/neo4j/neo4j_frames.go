@@ -0,0 +1,178 @@
+package neo4j
+
+import (
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/dag"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/Fantom-foundation/lachesis-base/inter/pos"
+	"github.com/Fantom-foundation/lachesis-base/kvdb/memorydb"
+	"github.com/Fantom-foundation/lachesis-base/vecfc"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// framesBatch is a number of events which frames are written in one transaction.
+const framesBatch = 100
+
+// maxFrameJump limits the frames event may skip over its self-parent, as lachesis does.
+const maxFrameJump = 100
+
+// RecomputeFrames derives the frames of epoch events from the stored DAG and writes them back.
+// Validators weights are not stored, so epoch creators are treated as equal weight validators.
+func (s *Db) RecomputeFrames(epoch idx.Epoch) error {
+	events, err := s.epochEvents(epoch)
+	if err != nil {
+		return err
+	}
+
+	frames, err := deriveFrames(events)
+	if err != nil {
+		return err
+	}
+
+	var changed []dag.Event
+	for _, e := range events {
+		if e.Frame() != frames[e.ID()] {
+			changed = append(changed, e)
+		}
+	}
+	if len(changed) > 0 {
+		s.Log.Warn("stored frames differ from derived", "epoch", epoch, "events", len(changed))
+	}
+
+	return s.writeFrames(changed, frames)
+}
+
+// writeFrames sets the frames of changed events by batches.
+func (s *Db) writeFrames(changed []dag.Event, frames map[hash.Event]idx.Frame) error {
+	for len(changed) > 0 {
+		n := framesBatch
+		if n > len(changed) {
+			n = len(changed)
+		}
+		batch := changed[:n]
+		changed = changed[n:]
+
+		_, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+			for _, e := range batch {
+				err := exec(ctx, `MATCH (e:Event %s) SET e.frame = %d`,
+					fields{"id": s.ids.str(e.ID())}, int64(frames[e.ID()]))
+				if err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// epochEvents returns events of epoch, parents go first.
+func (s *Db) epochEvents(epoch idx.Epoch) ([]dag.Event, error) {
+	var events []dag.Event
+	err := s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			OPTIONAL MATCH (e)-[:PARENT]->(p:Event)
+			WITH e, collect(p.id) AS edges
+			RETURN e, edges ORDER BY e.lamport, e.id`, fields{
+			"epoch": int64(epoch),
+		})
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			rec := cursor.Record()
+			events = append(events, readEventWithEdges(rec).Event)
+		}
+		return cursor.Err()
+	})
+
+	return events, err
+}
+
+// deriveFrames assigns frames to events the way lachesis does: event is a root of the next frame
+// if it is forkless caused by the quorum of roots of its frame.
+// Events are ordered parents first.
+func deriveFrames(events []dag.Event) (frames map[hash.Event]idx.Frame, err error) {
+	var (
+		byID     = make(map[hash.Event]dag.Event, len(events))
+		creators []idx.ValidatorID
+		seen     = make(map[idx.ValidatorID]struct{})
+	)
+	for _, e := range events {
+		byID[e.ID()] = e
+		if _, ok := seen[e.Creator()]; !ok {
+			seen[e.Creator()] = struct{}{}
+			creators = append(creators, e.Creator())
+		}
+	}
+	validators := pos.EqualWeightValidators(creators, 1)
+
+	// vector index reports the inconsistent DAG by crit
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+				return
+			}
+			panic(r)
+		}
+	}()
+	crit := func(err error) {
+		panic(err)
+	}
+	vi := vecfc.NewIndex(crit, vecfc.LiteConfig())
+	vi.Reset(validators, memorydb.New(), func(id hash.Event) dag.Event {
+		return byID[id]
+	})
+
+	type root struct {
+		id      hash.Event
+		creator idx.ValidatorID
+	}
+	var (
+		roots      = make(map[idx.Frame][]root)
+		quorumOnto = func(e dag.Event, f idx.Frame) bool {
+			counter := validators.NewCounter()
+			for _, r := range roots[f] {
+				if vi.ForklessCause(e.ID(), r.id) {
+					counter.Count(r.creator)
+				}
+				if counter.HasQuorum() {
+					return true
+				}
+			}
+			return false
+		}
+	)
+
+	frames = make(map[hash.Event]idx.Frame, len(events))
+	for _, e := range events {
+		if err = vi.Add(e); err != nil {
+			return nil, err
+		}
+
+		var selfParentFrame idx.Frame
+		if sp := e.SelfParent(); sp != nil {
+			selfParentFrame = frames[*sp]
+		}
+		f := selfParentFrame
+		for f < selfParentFrame+maxFrameJump && quorumOnto(e, f) {
+			f++
+		}
+		if f == 0 {
+			f = 1
+		}
+
+		frames[e.ID()] = f
+		if f != selfParentFrame {
+			roots[f] = append(roots[f], root{e.ID(), e.Creator()})
+		}
+	}
+
+	return frames, nil
+}
@@ -0,0 +1,325 @@
+package neo4j
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// graphMLAttrs are the kinds of event fields by the node attribute names of GraphML.
+var graphMLAttrs = map[string]string{
+	"block":           "long",
+	"role":            "string",
+	"epoch":           "long",
+	"seq":             "long",
+	"frame":           "long",
+	"lamport":         "long",
+	"creator":         "long",
+	"creation_time":   "long",
+	"median_time":     "long",
+	"gas_power_used":  "long",
+	"gas_power_left":  "longs",
+	"tx_hash":         "string",
+	"extra":           "bytes",
+	"prev_epoch_hash": "string",
+}
+
+// graphMLRequired are the node attributes without defaults.
+var graphMLRequired = []string{"epoch", "seq", "lamport", "creator"}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// graphML is a GraphML document being parsed. The events are emitted as soon as they and their parents are complete,
+// so only the incomplete events and the ids of the emitted ones are held.
+type graphML struct {
+	keys    map[string]string
+	nodes   map[hash.Event]*graphMLEvent
+	edges   map[hash.Event]hash.Events
+	emitted map[hash.Event]struct{}
+	// waiting are the complete events by the parents they wait for
+	waiting map[hash.Event]hash.Events
+
+	has  func(hash.Event) bool
+	emit func(*internal.EventInfo)
+}
+
+// graphMLEvent is a node which is not emitted yet.
+type graphMLEvent struct {
+	ff fields
+	// info is set when the node edges are complete, it is known by the event hash
+	info *internal.EventInfo
+	// blockers is the number of parents which are not emitted yet
+	blockers int
+}
+
+// ImportGraphML loads the events from GraphML document: the nodes are events and the edges go from child to parent.
+// Node id is the event id, node attributes are the event fields named as the Event properties (see graphMLAttrs),
+// gas_power_left is a JSON array, extra is a hex string. Payload is not imported.
+// Edges of an event have to be in the order of its parents, the event hash is checked against the node id.
+// The document is streamed into Load: event is loaded once its edges are complete and its parents are loaded
+// or are in db already, so the nodes and edges may go in any order.
+// Malformed keys, nodes and edges stop the import with error, the events loaded before stay in db.
+func (s *Db) ImportGraphML(r io.Reader) error {
+	loader := s.newEventsLoader()
+	err := parseGraphML(r, s.HasEvent, loader.add)
+	if closeErr := loader.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parseGraphML emits the document events parents first. Parents which are not in the document have to be in db,
+// has reports it.
+func parseGraphML(r io.Reader, has func(hash.Event) bool, emit func(*internal.EventInfo)) error {
+	g := &graphML{
+		keys:    make(map[string]string),
+		nodes:   make(map[hash.Event]*graphMLEvent),
+		edges:   make(map[hash.Event]hash.Events),
+		emitted: make(map[hash.Event]struct{}),
+		waiting: make(map[hash.Event]hash.Events),
+		has:     has,
+		emit:    emit,
+	}
+
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "key":
+			var k graphMLKey
+			if err = dec.DecodeElement(&k, &start); err == nil {
+				err = g.addKey(k)
+			}
+		case "node":
+			var n graphMLNode
+			if err = dec.DecodeElement(&n, &start); err == nil {
+				err = g.addNode(n)
+			}
+		case "edge":
+			var e graphMLEdge
+			if err = dec.DecodeElement(&e, &start); err == nil {
+				err = g.addEdge(e)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return g.end()
+}
+
+func (g *graphML) addKey(k graphMLKey) error {
+	if k.For != "node" && k.For != "all" {
+		return nil
+	}
+	kind, ok := graphMLAttrs[k.Name]
+	if !ok {
+		return fmt.Errorf("key %s: unknown node attribute %q", k.ID, k.Name)
+	}
+	if !graphMLTypeOf(kind, k.Type) {
+		return fmt.Errorf("key %s: attribute %s of type %q, %s is expected", k.ID, k.Name, k.Type, kind)
+	}
+	g.keys[k.ID] = k.Name
+	return nil
+}
+
+// graphMLTypeOf checks that the attr.type of key holds the kind of values.
+func graphMLTypeOf(kind, typ string) bool {
+	if kind == "long" {
+		return typ == "long" || typ == "int"
+	}
+	// string is the default type
+	return typ == "string" || typ == ""
+}
+
+func (g *graphML) addNode(n graphMLNode) error {
+	id, err := str2eventId(n.ID)
+	if err != nil {
+		return fmt.Errorf("node: %v", err)
+	}
+	if g.seen(id) {
+		return fmt.Errorf("node %s: duplicated", n.ID)
+	}
+
+	ff := fields{
+		"id":   n.ID,
+		"role": "",
+	}
+	for _, d := range n.Data {
+		name, ok := g.keys[d.Key]
+		if !ok {
+			return fmt.Errorf("node %s: unknown key %q", n.ID, d.Key)
+		}
+		v, err := parseGraphMLValue(graphMLAttrs[name], d.Value)
+		if err != nil {
+			return fmt.Errorf("node %s: attribute %s: %v", n.ID, name, err)
+		}
+		ff[name] = v
+	}
+	for _, name := range graphMLRequired {
+		if _, ok := ff[name]; !ok {
+			return fmt.Errorf("node %s: no attribute %s", n.ID, name)
+		}
+	}
+	if toInt64(ff["epoch"]) != int64(id.Epoch()) || toInt64(ff["lamport"]) != int64(id.Lamport()) {
+		return fmt.Errorf("node %s: epoch %d and lamport %d differ from id", n.ID, ff["epoch"], ff["lamport"])
+	}
+
+	g.nodes[id] = &graphMLEvent{ff: ff}
+	g.complete(id)
+	return nil
+}
+
+func parseGraphMLValue(kind, s string) (interface{}, error) {
+	switch kind {
+	case "long":
+		return strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	case "longs":
+		var nn []int64
+		err := json.Unmarshal([]byte(s), &nn)
+		return nn, err
+	case "bytes":
+		s = strings.TrimSpace(s)
+		_, err := hexutil.Decode(s)
+		return s, err
+	default:
+		return s, nil
+	}
+}
+
+func (g *graphML) addEdge(e graphMLEdge) error {
+	child, err := str2eventId(e.Source)
+	if err != nil {
+		return fmt.Errorf("edge: %v", err)
+	}
+	parent, err := str2eventId(e.Target)
+	if err != nil {
+		return fmt.Errorf("edge: %v", err)
+	}
+	if n, ok := g.nodes[child]; ok && n.info != nil || g.isEmitted(child) {
+		return fmt.Errorf("edge %s -> %s: event has all its parents already", e.Source, e.Target)
+	}
+
+	g.edges[child] = append(g.edges[child], parent)
+	if _, ok := g.nodes[child]; ok {
+		g.complete(child)
+	}
+	return nil
+}
+
+func (g *graphML) seen(id hash.Event) bool {
+	_, ok := g.nodes[id]
+	return ok || g.isEmitted(id)
+}
+
+func (g *graphML) isEmitted(id hash.Event) bool {
+	_, ok := g.emitted[id]
+	return ok
+}
+
+// complete checks if the node edges are complete and emits it when its parents are emitted.
+func (g *graphML) complete(id hash.Event) {
+	n := g.nodes[id]
+	n.ff["parents"] = g.edges[id]
+	info := readEvent(n.ff)
+	if info.Event.ID() != id {
+		return
+	}
+	n.info = info
+	delete(g.edges, id)
+
+	for _, p := range info.Event.Parents() {
+		if g.isEmitted(p) {
+			continue
+		}
+		// the parent which is not in the document yet may be in db
+		if _, ok := g.nodes[p]; ok || !g.has(p) {
+			g.waiting[p] = append(g.waiting[p], id)
+			n.blockers++
+		}
+	}
+	if n.blockers == 0 {
+		g.release(id)
+	}
+}
+
+// release emits the complete event and then the events which wait only for it.
+func (g *graphML) release(id hash.Event) {
+	for queue := (hash.Events{id}); len(queue) > 0; queue = queue[1:] {
+		e := queue[0]
+		g.emit(g.nodes[e].info)
+		g.emitted[e] = struct{}{}
+		delete(g.nodes, e)
+
+		for _, child := range g.waiting[e] {
+			c := g.nodes[child]
+			c.blockers--
+			if c.blockers == 0 {
+				queue = append(queue, child)
+			}
+		}
+		delete(g.waiting, e)
+	}
+}
+
+// end reports the edges and nodes which are not emitted by the end of document.
+func (g *graphML) end() error {
+	for child := range g.edges {
+		if !g.seen(child) {
+			return fmt.Errorf("edge of unknown node %s", eventId2str(child))
+		}
+	}
+	for id, n := range g.nodes {
+		if n.info == nil {
+			return fmt.Errorf("node %s: event hash differs, attributes or parents order are wrong", eventId2str(id))
+		}
+	}
+	for p, children := range g.waiting {
+		if !g.seen(p) {
+			return fmt.Errorf("node %s: parent %s is not found", eventId2str(children[0]), eventId2str(p))
+		}
+	}
+	if len(g.nodes) > 0 {
+		return fmt.Errorf("%d events refer to each other as parents", len(g.nodes))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package neo4j
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// indexPollInterval is a period of the index states polling while the indexes are populated.
+const indexPollInterval = time.Second
+
+// IndexState is a population state of db index.
+type IndexState struct {
+	Name string
+	// State is "ONLINE" for the index in use, "POPULATING" for the index which is being built or "FAILED".
+	State string
+	// Percent of the populated index.
+	Percent float64
+}
+
+// IndexStates returns the states of all the db indexes.
+func (s *Db) IndexStates() ([]IndexState, error) {
+	query, err := s.dialect.indexes()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		var states []IndexState
+		for cursor.Next() {
+			rec := cursor.Record()
+			state := IndexState{
+				Name:  rec.GetByIndex(0).(string),
+				State: rec.GetByIndex(1).(string),
+			}
+			if p, ok := rec.GetByIndex(2).(float64); ok {
+				state.Percent = p
+			}
+			states = append(states, state)
+		}
+		return states, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]IndexState), nil
+}
+
+// awaitIndexes blocks until all the db indexes are online and reports the populating ones to progress.
+// Server which doesn't report the index states isn't waited for, as before.
+func (s *Db) awaitIndexes(progress func([]IndexState)) error {
+	for {
+		states, err := s.IndexStates()
+		if errors.Is(err, ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			s.Log.Warn("index states are unknown", "err", err)
+			return nil
+		}
+		online, err := indexesOnline(states)
+		if online || err != nil {
+			return err
+		}
+
+		progress(states)
+		time.Sleep(indexPollInterval)
+	}
+}
+
+// indexesOnline returns true if all the indexes are online, failed index is an error.
+func indexesOnline(states []IndexState) (bool, error) {
+	online := true
+	for _, state := range states {
+		switch state.State {
+		case "ONLINE":
+		case "FAILED":
+			return false, fmt.Errorf("index %s is failed", state.Name)
+		default:
+			online = false
+		}
+	}
+	return online, nil
+}
+
+// logIndexProgress is the index progress by default.
+func (s *Db) logIndexProgress(states []IndexState) {
+	for _, state := range states {
+		if state.State != "ONLINE" {
+			s.Log.Info("index is populating", "name", state.Name, "state", state.State, "percent", state.Percent)
+		}
+	}
+}
@@ -0,0 +1,827 @@
+//go:build integration
+// +build integration
+
+package neo4j
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+	"github.com/ory/dockertest/v3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// testUrl is the db of integration tests, empty if there is no db.
+var testUrl string
+
+// testDbs is a number of the test dbs opened.
+var testDbs int32
+
+// TestMain runs the tests against db from NEO4J_URL or against a neo4j docker container.
+func TestMain(m *testing.M) {
+	testUrl = os.Getenv("NEO4J_URL")
+	if testUrl != "" {
+		os.Exit(m.Run())
+	}
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		fmt.Println("docker is not available:", err)
+		os.Exit(m.Run())
+	}
+	container, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "neo4j",
+		Tag:        "4.1.3",
+		Env:        []string{"NEO4J_AUTH=none"},
+	})
+	if err != nil {
+		fmt.Println("neo4j container is not started:", err)
+		os.Exit(m.Run())
+	}
+
+	url := "bolt://localhost:" + container.GetPort("7687/tcp")
+	pool.MaxWait = 2 * time.Minute
+	err = pool.Retry(func() error {
+		db, err := New(url, Options{})
+		if err != nil {
+			return err
+		}
+		return db.Close()
+	})
+	if err == nil {
+		testUrl = url
+	} else {
+		fmt.Println("neo4j container is not ready:", err)
+	}
+
+	code := m.Run()
+	if err = pool.Purge(container); err != nil {
+		fmt.Println("neo4j container is not removed:", err)
+	}
+	os.Exit(code)
+}
+
+// testDb connects to the test db. Each test has its own labels, so tests don't see each other's data.
+func testDb(t *testing.T) *Db {
+	return testDbWith(t, Options{})
+}
+
+// testDbWith is a testDb with opts.
+func testDbWith(t *testing.T, opts Options) *Db {
+	if testUrl == "" {
+		t.Skip("neo4j is not available")
+	}
+
+	prefix := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, t.Name())
+	// several dbs of one test are apart too
+	opts.LabelPrefix = fmt.Sprintf("%s%s%d", prefix, time.Now().Format("150405"), atomic.AddInt32(&testDbs, 1))
+	db, err := New(testUrl, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// load writes events into db and waits until they are written.
+func load(t *testing.T, db *Db, events []*inter.Event) {
+	var written sync.WaitGroup
+	input := make(chan *internal.EventInfo, len(events))
+	for _, e := range events {
+		written.Add(1)
+		input <- &internal.EventInfo{
+			Block:   1,
+			Event:   e,
+			Dispose: written.Done,
+		}
+	}
+	close(input)
+
+	db.Load(input)
+	written.Wait()
+}
+
+func TestNewFreshDb(t *testing.T) {
+	require := require.New(t)
+	// testDbWith opens New once, with no retry, on the labels nobody used yet
+	db := testDb(t)
+
+	version, err := db.GetSchemaVersion()
+	require.NoError(err)
+	require.Equal(int64(SchemaVersion), version)
+	schemas, err := db.count(`MATCH (s:Schema) RETURN count(s)`)
+	require.NoError(err)
+	require.Equal(int64(1), schemas)
+
+	_, err = db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `MATCH (s:Schema) DELETE s`)
+	})
+	require.NoError(err)
+	version, err = db.readSchemaVersion()
+	require.NoError(err)
+	require.Zero(version)
+	schemas, err = db.count(`MATCH (s:Schema) RETURN count(s)`)
+	require.NoError(err)
+	require.Zero(schemas)
+}
+
+func TestIsAncestorCrossEpoch(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 10, 1)
+	last := events[len(events)-1]
+	next := nextEpochEvent(last)
+	load(t, db, append(events, next))
+
+	ancestor, err := db.IsAncestor(last.ID(), next.ID())
+	require.NoError(err)
+	require.True(ancestor)
+	ancestor, err = db.IsAncestor(next.ID(), last.ID())
+	require.NoError(err)
+	require.False(ancestor)
+}
+
+func TestGetAncestorsWithinEpoch(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 10, 1)
+	root := nextEpochEvent(events[len(events)-1])
+	child := &inter.MutableEventPayload{}
+	child.SetEpoch(root.Epoch())
+	child.SetCreator(root.Creator())
+	child.SetSeq(2)
+	child.SetLamport(2)
+	child.SetCreationTime(root.CreationTime() + 1)
+	child.SetMedianTime(root.MedianTime() + 1)
+	child.SetParents(hash.Events{root.ID()})
+	tip := &child.Build().Event
+	load(t, db, append(events, root, tip))
+
+	ancestors, err := db.GetAncestorsWithinEpoch(tip.ID())
+	require.NoError(err)
+	require.Equal([]hash.Event{root.ID()}, ancestors)
+}
+
+func TestLoadGetEvent(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	load(t, db, events)
+
+	for _, e := range events {
+		require.True(db.HasEvent(e.ID()))
+		db.cache.EventInfos.Purge()
+		got, err := db.GetEvent(e.ID())
+		require.NoError(err)
+		require.NotNil(got)
+		require.Equal(e.ID(), got.Event.ID())
+		require.Equal(e.Parents(), got.Event.Parents())
+	}
+	got, err := db.GetEvent(hash.FakeEvent())
+	require.NoError(err)
+	require.Nil(got)
+}
+
+func TestLoadShuffledBatch(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 3, 30, 3)
+	shuffled := make([]*inter.Event, len(events))
+	for i, j := range rand.New(rand.NewSource(3)).Perm(len(events)) {
+		shuffled[i] = events[j]
+	}
+
+	ordered := testDbWith(t, Options{LoadBatch: len(events)})
+	load(t, ordered, events)
+	db := testDbWith(t, Options{LoadBatch: len(events)})
+	load(t, db, shuffled)
+
+	for _, e := range events {
+		ordered.cache.EventInfos.Purge()
+		db.cache.EventInfos.Purge()
+		expect, err := ordered.GetEvent(e.ID())
+		require.NoError(err)
+		got, err := db.GetEvent(e.ID())
+		require.NoError(err)
+		require.NotNil(got)
+		require.Equal(expect.Event.Parents(), got.Event.Parents())
+
+		expectChildren, err := ordered.GetChildren(e.ID())
+		require.NoError(err)
+		children, err := db.GetChildren(e.ID())
+		require.NoError(err)
+		require.Equal(expectChildren, children)
+	}
+}
+
+func TestLoadFindAncestors(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 2)
+	load(t, db, events)
+
+	byID := make(map[hash.Event]*inter.Event)
+	for _, e := range events {
+		byID[e.ID()] = e
+	}
+	last := events[len(events)-1]
+
+	// expected ancestors by DFS
+	expected := make(map[hash.Event]struct{})
+	var visit func(hash.Event)
+	visit = func(id hash.Event) {
+		for _, p := range byID[id].Parents() {
+			if _, ok := expected[p]; !ok {
+				expected[p] = struct{}{}
+				visit(p)
+			}
+		}
+	}
+	visit(last.ID())
+
+	ancestors, err := db.FindAncestors(last.ID())
+	require.NoError(err)
+	require.Len(ancestors, len(expected))
+	for _, a := range ancestors {
+		require.Contains(expected, a)
+	}
+
+	bfs, err := db.GetAncestorsBFS(last.ID(), 4)
+	require.NoError(err)
+	require.ElementsMatch(ancestors, bfs)
+
+	// union of the tips ancestors
+	tips := []hash.Event{last.ID(), events[len(events)-2].ID(), events[len(events)-3].ID()}
+	union := make(map[hash.Event]struct{})
+	for _, tip := range tips {
+		ancestors, err := db.FindAncestors(tip)
+		require.NoError(err)
+		for _, a := range ancestors {
+			union[a] = struct{}{}
+		}
+	}
+	multi, err := db.FindAncestorsMulti(tips)
+	require.NoError(err)
+	require.Len(multi, len(union))
+	for _, a := range multi {
+		require.Contains(union, a)
+	}
+}
+
+func TestGetRoots(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	var expected []hash.Event
+	for _, e := range events {
+		if e.Seq() == 1 {
+			expected = append(expected, e.ID())
+		}
+	}
+
+	for _, opts := range []Options{{}, {RootLabel: true}} {
+		db := testDbWith(t, opts)
+		load(t, db, events)
+
+		roots, err := db.GetRoots(1)
+		require.NoError(err)
+		require.ElementsMatch(expected, roots)
+	}
+}
+
+func TestIterateByTime(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	load(t, db, events)
+
+	var (
+		prev  inter.Timestamp
+		count int
+	)
+	err := db.IterateByTime(1, func(info *internal.EventInfo) error {
+		e := info.Event.(inter.EventI)
+		require.LessOrEqual(prev, e.CreationTime())
+		prev = e.CreationTime()
+		count++
+		return nil
+	})
+	require.NoError(err)
+	require.Equal(len(events), count)
+
+	stop := errors.New("stop")
+	err = db.IterateByTime(1, func(*internal.EventInfo) error {
+		return stop
+	})
+	require.Equal(stop, err)
+}
+
+func TestGetEventWindow(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	load(t, db, events)
+
+	const lo, hi = 3, 6
+	expected := make(map[hash.Event]*inter.Event)
+	for _, e := range events {
+		if e.Lamport() >= lo && e.Lamport() <= hi {
+			expected[e.ID()] = e
+		}
+	}
+
+	window, err := db.GetEventWindow(1, lo, hi)
+	require.NoError(err)
+	require.Len(window, len(expected))
+	for i, info := range window {
+		e := expected[info.Event.ID()]
+		require.NotNil(e)
+		// parents out of window are referenced too
+		require.Equal(e.Parents(), info.Event.Parents())
+		if i > 0 {
+			require.LessOrEqual(window[i-1].Event.Lamport(), info.Event.Lamport())
+		}
+	}
+
+	_, err = db.GetEventWindow(1, hi, lo)
+	require.Error(err)
+}
+
+func TestGetEdgesFor(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	load(t, db, events)
+
+	var (
+		es       []hash.Event
+		expected []EdgePair
+	)
+	for _, e := range events[10:20] {
+		es = append(es, e.ID())
+		for _, p := range e.Parents() {
+			expected = append(expected, EdgePair{Child: e.ID(), Parent: p})
+		}
+	}
+
+	edges, err := db.GetEdgesFor(es)
+	require.NoError(err)
+	require.ElementsMatch(expected, edges)
+
+	edges, err = db.GetEdgesFor(nil)
+	require.NoError(err)
+	require.Empty(edges)
+}
+
+func TestLoadEpochChecksum(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	hex := testDbWith(t, Options{})
+	load(t, hex, events)
+	b64 := testDbWith(t, Options{IdEncoding: Base64Ids})
+	load(t, b64, events)
+
+	sum, err := hex.EpochChecksum(1)
+	require.NoError(err)
+	require.NotEqual([32]byte{}, sum)
+	other, err := b64.EpochChecksum(1)
+	require.NoError(err)
+	require.Equal(sum, other)
+
+	other, err = hex.EpochChecksum(2)
+	require.NoError(err)
+	require.NotEqual(sum, other)
+}
+
+func TestLastImported(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	_, _, err := db.LastImported()
+	require.Equal(ErrNotFound, err)
+
+	events := SyntheticDAG(1, 3, 10, 1)
+	start := time.Now().Add(-time.Minute)
+	load(t, db, events)
+
+	var ids hash.Events
+	for _, e := range events {
+		ids = append(ids, e.ID())
+	}
+	last, at, err := db.LastImported()
+	require.NoError(err)
+	require.Contains(ids, last)
+	require.True(at.After(start), at)
+}
+
+func TestMigrateTo(t *testing.T) {
+	require := require.New(t)
+
+	src := testDbWith(t, Options{})
+	events := append(SyntheticDAG(1, 3, 20, 1), SyntheticDAG(2, 3, 20, 2)...)
+	load(t, src, events)
+	dst := testDbWith(t, Options{IdEncoding: Base64Ids, LoadBatch: 10})
+
+	migrated := make(map[idx.Epoch]int)
+	progress := func(epoch idx.Epoch, events int) {
+		migrated[epoch] = events
+	}
+	require.NoError(src.MigrateTo(dst, MigrateOptions{Progress: progress}))
+	require.Equal(map[idx.Epoch]int{1: 20, 2: 20}, migrated)
+
+	for _, epoch := range []idx.Epoch{1, 2} {
+		expect, err := src.EpochChecksum(epoch)
+		require.NoError(err)
+		got, err := dst.EpochChecksum(epoch)
+		require.NoError(err)
+		require.Equal(expect, got)
+	}
+
+	// resumed from the last epoch, which is complete
+	migrated = make(map[idx.Epoch]int)
+	require.NoError(src.MigrateTo(dst, MigrateOptions{Progress: progress}))
+	require.Equal(map[idx.Epoch]int{2: 0}, migrated)
+}
+
+func TestFindEpochOrderViolations(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	epoch1, epoch2 := SyntheticDAG(1, 3, 10, 1), SyntheticDAG(2, 3, 10, 2)
+	load(t, db, append(epoch1, epoch2...))
+
+	violations, err := db.FindEpochOrderViolations()
+	require.NoError(err)
+	require.Empty(violations)
+
+	bad := EdgePair{Child: epoch1[len(epoch1)-1].ID(), Parent: epoch2[0].ID()}
+	edges := make(chan [2]hash.Event, 1)
+	edges <- [2]hash.Event{bad.Child, bad.Parent}
+	close(edges)
+	require.NoError(db.LoadEdges(edges))
+
+	violations, err = db.FindEpochOrderViolations()
+	require.NoError(err)
+	require.Equal([]EdgePair{bad}, violations)
+}
+
+func TestDeleteEpoch(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	load(t, db, append(SyntheticDAG(1, 3, 25, 1), SyntheticDAG(2, 3, 10, 2)...))
+
+	_, err := db.DeleteEpoch(2, DeleteEpochOptions{})
+	require.Equal(ErrCurrentEpoch, err)
+
+	var progress [][2]int64
+	deleted, err := db.DeleteEpoch(1, DeleteEpochOptions{
+		Chunk: 10,
+		Progress: func(deleted, total int64) {
+			progress = append(progress, [2]int64{deleted, total})
+		},
+	})
+	require.NoError(err)
+	require.Equal(int64(25), deleted)
+	require.Equal([][2]int64{{10, 25}, {20, 25}, {25, 25}}, progress)
+
+	deleting, err := db.GetDeletingEpochs()
+	require.NoError(err)
+	require.Empty(deleting)
+	epochs, err := db.storedEpochs()
+	require.NoError(err)
+	require.Equal([]idx.Epoch{2}, epochs)
+
+	deleted, err = db.DeleteEpoch(2, DeleteEpochOptions{Force: true})
+	require.NoError(err)
+	require.Equal(int64(10), deleted)
+}
+
+func TestEventDegree(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	load(t, db, events)
+
+	children := make(map[hash.Event]int64)
+	for _, e := range events {
+		for _, p := range e.Parents() {
+			children[p]++
+		}
+	}
+	for _, e := range events {
+		in, out, err := db.EventDegree(e.ID())
+		require.NoError(err)
+		require.Equal(children[e.ID()], in)
+		require.Equal(int64(len(e.Parents())), out)
+	}
+
+	_, _, err := db.EventDegree(hash.FakeEvent())
+	require.Equal(ErrNotFound, err)
+}
+
+func TestTopologicalOrder(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 30, 1)
+	load(t, db, events)
+
+	order, err := db.TopologicalOrder(1)
+	require.NoError(err)
+	require.Len(order, len(events))
+	pos := make(map[hash.Event]int, len(order))
+	for i, e := range order {
+		pos[e] = i
+	}
+	for _, e := range events {
+		for _, p := range e.Parents() {
+			require.Less(pos[p], pos[e.ID()])
+		}
+	}
+
+	again, err := db.TopologicalOrder(1)
+	require.NoError(err)
+	require.Equal(order, again)
+}
+
+func TestTrustedBulk(t *testing.T) {
+	require := require.New(t)
+	db := testDbWith(t, Options{TrustedBulk: true})
+
+	events := SyntheticDAG(1, 3, 10, 1)
+	load(t, db, events)
+
+	dup := events[len(events)/2].ID()
+	_, err := db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `CREATE (e:Event %s)`, fields{"id": db.ids.str(dup)})
+	})
+	require.NoError(err)
+
+	dups, err := db.EnsureConstraints()
+	require.Error(err)
+	require.Equal(hash.Events{dup}, dups)
+
+	_, err = db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `MATCH (e:Event %s) WHERE e.epoch IS NULL DELETE e`, fields{"id": db.ids.str(dup)})
+	})
+	require.NoError(err)
+
+	dups, err = db.EnsureConstraints()
+	require.NoError(err)
+	require.Empty(dups)
+
+	_, err = db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `CREATE (e:Event %s)`, fields{"id": db.ids.str(dup)})
+	})
+	require.Error(err)
+}
+
+func TestImportGraphML(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	// lamport restarts in the next epoch, its event still goes after the parent
+	events := SyntheticDAG(1, 3, 20, 1)
+	events = append(events, nextEpochEvent(events[len(events)-1]))
+	doc := testGraphML(events)
+	require.NoError(db.ImportGraphML(strings.NewReader(doc)))
+
+	for _, e := range events {
+		got, err := db.GetEvent(e.ID())
+		require.NoError(err)
+		require.NotNil(got)
+		require.Equal(e.Parents(), got.Event.Parents())
+	}
+	edges, err := db.CountEdges()
+	require.NoError(err)
+	var parents int
+	for _, e := range events {
+		parents += len(e.Parents())
+	}
+	require.Equal(int64(parents), edges)
+
+	// events in db already are skipped
+	require.NoError(db.ImportGraphML(strings.NewReader(doc)))
+}
+
+func TestExportSince(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 10, 1)
+	load(t, db, events)
+
+	// events are old enough for export, the halves are imported in the same milliseconds
+	old := time.Now().Add(-2 * exportLag)
+	setImported := func(ee []*inter.Event, at time.Time) {
+		ids := make(hash.Events, len(ee))
+		for i, e := range ee {
+			ids[i] = e.ID()
+		}
+		_, err := db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+			return nil, exec(ctx, `UNWIND %s AS id MATCH (e:Event {id: id}) SET e.imported_at = %d`,
+				valToString(db.ids.strs(ids)), at.UnixNano()/int64(time.Millisecond))
+		})
+		require.NoError(err)
+	}
+	first, second := old.Truncate(time.Millisecond), old.Add(time.Millisecond).Truncate(time.Millisecond)
+	setImported(events[:5], first)
+	setImported(events[5:], second)
+
+	var out bytes.Buffer
+	watermark, err := db.ExportSince(&out, time.Time{})
+	require.NoError(err)
+	require.Equal(10, strings.Count(out.String(), "\n"))
+	require.WithinDuration(second, watermark, 0)
+
+	out.Reset()
+	watermark, err = db.ExportSince(&out, first)
+	require.NoError(err)
+	require.Equal(5, strings.Count(out.String(), "\n"))
+	require.WithinDuration(second, watermark, 0)
+
+	// the recent events are not exported yet
+	last := nextEpochEvent(events[len(events)-1])
+	load(t, db, []*inter.Event{last})
+	out.Reset()
+	again, err := db.ExportSince(&out, watermark)
+	require.NoError(err)
+	require.Zero(out.Len())
+	require.WithinDuration(watermark, again, 0)
+
+	setImported([]*inter.Event{last}, second.Add(time.Millisecond))
+	out.Reset()
+	again, err = db.ExportSince(&out, watermark)
+	require.NoError(err)
+	require.Equal(1, strings.Count(out.String(), "\n"))
+	require.WithinDuration(second.Add(time.Millisecond), again, 0)
+}
+
+func TestPurgeAll(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	load(t, db, SyntheticDAG(1, 3, 10, 1))
+	require.Equal(ErrNotConfirmed, db.PurgeAll(false))
+	require.NoError(db.PurgeAll(true))
+
+	events, err := db.CountEvents()
+	require.NoError(err)
+	require.Zero(events)
+	version, err := db.GetSchemaVersion()
+	require.NoError(err)
+	require.Equal(int64(SchemaVersion), version)
+	schemas, err := db.count(`MATCH (s:Schema) RETURN count(s)`)
+	require.NoError(err)
+	require.Equal(int64(1), schemas)
+}
+
+func TestBase64IdsRoundTrip(t *testing.T) {
+	require := require.New(t)
+	db := testDbWith(t, Options{IdEncoding: Base64Ids})
+
+	id := labelLikeEventId()
+	_, err := db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `CREATE (e:Event %s)`, fields{"id": db.ids.str(id), "epoch": int64(id.Epoch())})
+	})
+	require.NoError(err)
+
+	require.True(db.HasEvent(id))
+	ids, err := db.TopologicalOrder(id.Epoch())
+	require.NoError(err)
+	require.Equal([]hash.Event{id}, ids)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	require := require.New(t)
+	src := testDb(t)
+	dst := testDb(t)
+
+	load(t, src, SyntheticDAG(1, 3, 30, 1))
+	var snapshot bytes.Buffer
+	require.NoError(src.Snapshot(&snapshot))
+
+	for i := 0; i < 2; i++ {
+		require.NoError(dst.Restore(bytes.NewReader(snapshot.Bytes())), "restore is idempotent")
+	}
+	expect, err := src.EpochChecksum(1)
+	require.NoError(err)
+	got, err := dst.EpochChecksum(1)
+	require.NoError(err)
+	require.Equal(expect, got)
+	edges, err := dst.CountEdges()
+	require.NoError(err)
+	srcEdges, err := src.CountEdges()
+	require.NoError(err)
+	require.Equal(srcEdges, edges)
+	require.Equal(src.GetLastBlock(), dst.GetLastBlock())
+}
+
+func TestAuditHashes(t *testing.T) {
+	require := require.New(t)
+	db := testDb(t)
+
+	events := SyntheticDAG(1, 3, 10, 1)
+	load(t, db, events)
+
+	mismatched, err := db.AuditHashes(1)
+	require.NoError(err)
+	require.Empty(mismatched)
+
+	corrupted := events[len(events)/2].ID()
+	_, err = db.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `MATCH (e:Event %s) SET e.seq = e.seq + 100`, fields{"id": db.ids.str(corrupted)})
+	})
+	require.NoError(err)
+
+	mismatched, err = db.AuditHashes(1)
+	require.NoError(err)
+	require.Equal([]hash.Event{corrupted}, mismatched)
+}
+
+func TestHasProcedure(t *testing.T) {
+	db := testDb(t)
+
+	require.NotNil(t, db.dialect.procedures)
+	require.True(t, db.HasProcedure("dbms.components"))
+	require.False(t, db.HasProcedure("no.such.procedure"))
+}
+
+func TestQueriesUseIndexes(t *testing.T) {
+	db := testDb(t)
+
+	// New waits for the indexes
+	states, err := db.IndexStates()
+	require.NoError(t, err)
+	require.NotEmpty(t, states)
+	online, err := indexesOnline(states)
+	require.NoError(t, err)
+	require.True(t, online)
+
+	assertUsesIndex(t, db, `MATCH (e:Event {epoch: 1}) WHERE e.finalized = false RETURN e.id`, "epoch")
+	assertUsesIndex(t, db, `MATCH (e:Event {epoch: 1}) RETURN DISTINCT e.creator`, "epoch")
+	assertUsesIndex(t, db, `MATCH (e:Event {creator: 1}) RETURN e.id`, "creator")
+	assertUsesIndex(t, db, `MATCH (e:Event {epoch: 1, creator: 1}) RETURN e.id ORDER BY e.seq`, "creator")
+	assertUsesIndex(t, db, `MATCH (e:Event {id: "0"}) RETURN e`, "id")
+}
+
+// assertUsesIndex fails if cypher is planned with label scan instead of seek by the indexed property.
+func assertUsesIndex(t *testing.T, db *Db, cypher string, property string) {
+	t.Helper()
+
+	plan, err := db.queryPlan(cypher, nil, false)
+	require.NoError(t, err)
+
+	var (
+		seek  bool
+		scans []string
+		walk  func(*planNode)
+	)
+	walk = func(n *planNode) {
+		if strings.Contains(n.Operator, "NodeByLabelScan") || strings.Contains(n.Operator, "AllNodesScan") {
+			scans = append(scans, n.Operator)
+		}
+		if strings.Contains(n.Operator, "IndexSeek") && strings.Contains(fmt.Sprint(n.Arguments), property) {
+			seek = true
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+	}
+	walk(plan)
+
+	var out strings.Builder
+	plan.format(&out, 0)
+	require.Empty(t, scans, "query scans nodes:\n%s\n%s", cypher, out.String())
+	require.True(t, seek, "query doesn't use %s index:\n%s\n%s", property, cypher, out.String())
+}
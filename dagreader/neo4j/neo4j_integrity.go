@@ -0,0 +1,292 @@
+package neo4j
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// FindOrphans returns events of epoch which have no PARENT edges but are not epoch roots:
+// their seq is greater than 1 or their parents property is not empty.
+// These are the events which edges are lost during import.
+func (s *Db) FindOrphans(epoch idx.Epoch) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			WHERE NOT (e)-[:PARENT]->() AND (e.seq > 1 OR size(coalesce(e.parents, [])) > 0)
+			RETURN e.id ORDER BY e.id`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// FindEpochOrderViolations returns the PARENT edges which parent is in a later epoch than child.
+// Such edges are never in a well-formed DAG, they are the corrupted data or the import bugs.
+func (s *Db) FindEpochOrderViolations() ([]EdgePair, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event)-[:PARENT]->(p:Event) WHERE p.epoch > e.epoch
+			RETURN e.id, p.id ORDER BY e.id, p.id`)
+		if err != nil {
+			return nil, err
+		}
+
+		var edges []EdgePair
+		for cursor.Next() {
+			edge, err := readEdgePair(cursor.Record())
+			if err != nil {
+				return nil, err
+			}
+			edges = append(edges, edge)
+		}
+		return edges, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]EdgePair), nil
+}
+
+// FindStaleTips returns events of epoch which have no children and lamport below beforeLamport:
+// the newer events exist, but none of them references these, so they are abandoned by the DAG.
+func (s *Db) FindStaleTips(epoch idx.Epoch, beforeLamport idx.Lamport) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			WHERE e.lamport < %d AND NOT (e)<-[:PARENT]-()
+			RETURN e.id ORDER BY e.id`,
+			fields{"epoch": int64(epoch)},
+			int64(beforeLamport),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// GetEventsMissingParents returns events of epoch with their parents which are not in db yet,
+// it is the waiting set of streaming import. Parents are taken from the parents property.
+func (s *Db) GetEventsMissingParents(epoch idx.Epoch) (map[hash.Event][]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) WHERE e.parents IS NOT NULL
+			UNWIND e.parents AS pid
+			OPTIONAL MATCH (p:Event {id: pid})
+			WITH e, pid, p WHERE p IS NULL
+			RETURN e.id, collect(pid)`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		missing := make(map[hash.Event][]hash.Event)
+		for cursor.Next() {
+			rec := cursor.Record()
+			id, err := readEventId(rec.GetByIndex(0))
+			if err != nil {
+				return nil, err
+			}
+			missing[id] = toEventIds(rec.GetByIndex(1))
+		}
+		return missing, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(map[hash.Event][]hash.Event), nil
+}
+
+// FindSequenceGaps returns the seq numbers between the min and max seq of creator events in epoch
+// which have no event: the dropped events of import or of validator.
+func (s *Db) FindSequenceGaps(creator idx.ValidatorID, epoch idx.Epoch) ([]idx.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN DISTINCT e.seq AS seq ORDER BY seq`, fields{
+			"epoch":   int64(epoch),
+			"creator": int64(creator),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var seqs []idx.Event
+		for cursor.Next() {
+			seqs = append(seqs, idx.Event(cursor.Record().GetByIndex(0).(int64)))
+		}
+		return seqs, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return seqGaps(res.([]idx.Event)), nil
+}
+
+// seqGaps returns the numbers missing in the sorted seqs.
+func seqGaps(seqs []idx.Event) []idx.Event {
+	var gaps []idx.Event
+	for i := 1; i < len(seqs); i++ {
+		for seq := seqs[i-1] + 1; seq < seqs[i]; seq++ {
+			gaps = append(gaps, seq)
+		}
+	}
+	return gaps
+}
+
+// FindMissingEpochs returns the epochs between the min and max stored epoch which have no event:
+// the skipped or failed to import epochs.
+func (s *Db) FindMissingEpochs() ([]idx.Epoch, error) {
+	epochs, err := s.storedEpochs()
+	if err != nil {
+		return nil, err
+	}
+
+	return epochGaps(epochs), nil
+}
+
+// storedEpochs returns the sorted epochs which have events.
+func (s *Db) storedEpochs() ([]idx.Epoch, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event) RETURN DISTINCT e.epoch AS epoch ORDER BY epoch`)
+		if err != nil {
+			return nil, err
+		}
+
+		var epochs []idx.Epoch
+		for cursor.Next() {
+			epochs = append(epochs, idx.Epoch(cursor.Record().GetByIndex(0).(int64)))
+		}
+		return epochs, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]idx.Epoch), nil
+}
+
+// epochGaps returns the epochs missing in the sorted epochs.
+func epochGaps(epochs []idx.Epoch) []idx.Epoch {
+	var gaps []idx.Epoch
+	for i := 1; i < len(epochs); i++ {
+		for epoch := epochs[i-1] + 1; epoch < epochs[i]; epoch++ {
+			gaps = append(gaps, epoch)
+		}
+	}
+	return gaps
+}
+
+// AuditHashes returns events of epoch which stored id differs from the hash of the event
+// unmarshaled from db: the fields lost by marshaling or the corrupted data.
+func (s *Db) AuditHashes(epoch idx.Epoch) ([]hash.Event, error) {
+	var mismatched []hash.Event
+	err := s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			OPTIONAL MATCH (e)-[:PARENT]->(p:Event)
+			WITH e, collect(p.id) AS edges
+			RETURN e, edges ORDER BY e.id`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			rec := cursor.Record()
+			stored, err := readEventId(rec.GetByIndex(0).(neo4j.Node).Props()["id"])
+			if err != nil {
+				return err
+			}
+			// event id is recalculated from the fields by unmarshal
+			if readEventWithEdges(rec).Event.ID() != stored {
+				mismatched = append(mismatched, stored)
+			}
+		}
+		return cursor.Err()
+	})
+
+	return mismatched, err
+}
+
+// EpochChecksum returns a hash of the epoch events and their parents, it is equal for the dbs with the same DAG
+// regardless of the IdEncoding. It is SHA-256 of the events sorted by 32 bytes id, each of them is written as
+// the id, the big endian uint32 number of parents and the parents ids sorted by bytes. Parents are taken from
+// the parents property, or from the PARENT edges of the events imported before the property was introduced.
+func (s *Db) EpochChecksum(epoch idx.Epoch) ([32]byte, error) {
+	var events []eventParents
+	err := s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			RETURN e.id, coalesce(e.parents, [(e)-[:PARENT]->(p:Event) | p.id])`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			rec := cursor.Record()
+			id, err := readEventId(rec.GetByIndex(0))
+			if err != nil {
+				return err
+			}
+			events = append(events, eventParents{id, toEventIds(rec.GetByIndex(1))})
+		}
+		return cursor.Err()
+	})
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return epochChecksum(events), nil
+}
+
+// eventParents is an event id with its parents ids.
+type eventParents struct {
+	id      hash.Event
+	parents hash.Events
+}
+
+// epochChecksum is the EpochChecksum of events, it sorts them and their parents.
+func epochChecksum(events []eventParents) [32]byte {
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].id.Bytes(), events[j].id.Bytes()) < 0
+	})
+
+	h := sha256.New()
+	for _, e := range events {
+		parents := append(hash.Events(nil), e.parents...)
+		sort.Slice(parents, func(i, j int) bool {
+			return bytes.Compare(parents[i].Bytes(), parents[j].Bytes()) < 0
+		})
+
+		h.Write(e.id.Bytes())
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(parents)))
+		h.Write(n[:])
+		for _, p := range parents {
+			h.Write(p.Bytes())
+		}
+	}
+
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
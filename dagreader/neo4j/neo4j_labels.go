@@ -0,0 +1,163 @@
+package neo4j
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/dag"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// defaultParentRelType is the type of event to parent relationship in Cypher of Db.
+const defaultParentRelType = "PARENT"
+
+var relTypeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// labeler replaces the default node labels and relationship type in Cypher.
+// Only the label and type tokens are replaced, the quoted strings (e.g. the inlined ids) and names are kept as is.
+type labeler struct {
+	tokens *regexp.Regexp
+	names  map[string]string
+}
+
+// newLabeler returns replacer of the default node labels in Cypher with the prefixed ones
+// and of the PARENT relationship type with Options.ParentRelType, or nil if there is nothing to replace.
+func newLabeler(opts Options) *labeler {
+	names := make(map[string]string)
+	if opts.LabelPrefix != "" {
+		for _, label := range []string{"Event", "Root", "State", "Block", "Schema"} {
+			names[":"+label] = ":" + opts.LabelPrefix + label
+		}
+	}
+	if rel := opts.ParentRelType; rel != "" && rel != defaultParentRelType {
+		names[":"+defaultParentRelType] = ":" + rel
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+	tokens := make([]string, 0, len(names))
+	for token := range names {
+		tokens = append(tokens, regexp.QuoteMeta(token))
+	}
+	sort.Strings(tokens)
+	return &labeler{
+		tokens: regexp.MustCompile(`(` + strings.Join(tokens, "|") + `)\b`),
+		names:  names,
+	}
+}
+
+// Replace returns cypher with the labels and type replaced.
+func (l *labeler) Replace(cypher string) string {
+	var (
+		buf  strings.Builder
+		from int
+	)
+	for i := 0; i < len(cypher); i++ {
+		quote := cypher[i]
+		if quote != '"' && quote != '\'' && quote != '`' {
+			continue
+		}
+		buf.WriteString(l.replace(cypher[from:i]))
+
+		// the string goes up to the closing quote, escaped quotes don't close it
+		end := i + 1
+		for ; end < len(cypher) && cypher[end] != quote; end++ {
+			if cypher[end] == '\\' && quote != '`' {
+				end++
+			}
+		}
+		if end < len(cypher) {
+			end++
+		} else {
+			end = len(cypher)
+		}
+		buf.WriteString(cypher[i:end])
+		from = end
+		i = end - 1
+	}
+	buf.WriteString(l.replace(cypher[from:]))
+	return buf.String()
+}
+
+func (l *labeler) replace(unquoted string) string {
+	return l.tokens.ReplaceAllStringFunc(unquoted, func(token string) string {
+		return l.names[token]
+	})
+}
+
+// eventLabels returns the node labels of event written by Load.
+func (s *Db) eventLabels(e dag.Event) string {
+	if s.rootLabel && e.SelfParent() == nil {
+		return ":Event:Root"
+	}
+	return ":Event"
+}
+
+// session opens db session which applies the Db labels to all the queries.
+// With Options.CausalConsistency the read sessions observe the Db writes.
+func (s *Db) session(mode neo4j.AccessMode) (neo4j.Session, error) {
+	var bookmarks []string
+	if s.bookmarks != nil && mode == neo4j.AccessModeRead {
+		bookmarks = s.bookmarks.get()
+	}
+	session, err := s.drv.Session(mode, bookmarks...)
+	if err != nil {
+		return nil, err
+	}
+	if s.bookmarks != nil && mode == neo4j.AccessModeWrite {
+		session = &bookmarkedSession{
+			Session:   session,
+			bookmarks: s.bookmarks,
+		}
+	}
+	if s.labeler == nil {
+		return session, nil
+	}
+
+	return &labeledSession{
+		Session: session,
+		labeler: s.labeler,
+	}, nil
+}
+
+type labeledSession struct {
+	neo4j.Session
+	labeler *labeler
+}
+
+func (s *labeledSession) BeginTransaction(configurers ...func(*neo4j.TransactionConfig)) (neo4j.Transaction, error) {
+	tx, err := s.Session.BeginTransaction(configurers...)
+	if err != nil {
+		return nil, err
+	}
+	return &labeledTx{tx, s.labeler}, nil
+}
+
+func (s *labeledSession) ReadTransaction(work neo4j.TransactionWork, configurers ...func(*neo4j.TransactionConfig)) (interface{}, error) {
+	return s.Session.ReadTransaction(s.labeled(work), configurers...)
+}
+
+func (s *labeledSession) WriteTransaction(work neo4j.TransactionWork, configurers ...func(*neo4j.TransactionConfig)) (interface{}, error) {
+	return s.Session.WriteTransaction(s.labeled(work), configurers...)
+}
+
+func (s *labeledSession) Run(cypher string, params map[string]interface{}, configurers ...func(*neo4j.TransactionConfig)) (neo4j.Result, error) {
+	return s.Session.Run(s.labeler.Replace(cypher), params, configurers...)
+}
+
+func (s *labeledSession) labeled(work neo4j.TransactionWork) neo4j.TransactionWork {
+	return func(tx neo4j.Transaction) (interface{}, error) {
+		return work(&labeledTx{tx, s.labeler})
+	}
+}
+
+type labeledTx struct {
+	neo4j.Transaction
+	labeler *labeler
+}
+
+func (tx *labeledTx) Run(cypher string, params map[string]interface{}) (neo4j.Result, error) {
+	return tx.Transaction.Run(tx.labeler.Replace(cypher), params)
+}
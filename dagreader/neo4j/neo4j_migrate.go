@@ -0,0 +1,108 @@
+package neo4j
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// MigrateOptions of MigrateTo. Zero value resumes the migration at full speed.
+type MigrateOptions struct {
+	// FromEpoch is the first migrated epoch, zero means the last epoch of the destination db,
+	// so the interrupted migration continues with it. Events which are in the destination already are skipped.
+	FromEpoch idx.Epoch
+	// MaxEventsPerSecond limits the migration rate, zero means no limit.
+	MaxEventsPerSecond int
+	// Progress is called after each migrated epoch with the number of events written.
+	Progress func(epoch idx.Epoch, events int)
+}
+
+// MigrateTo copies the events and their PARENT edges into dst, e.g. a db of the newer server version.
+// Epochs are copied in order by the dst Load, each epoch is written completely before the next one.
+// Only the event fields go over: payloads, custom properties and finalized marks are not copied.
+func (s *Db) MigrateTo(dst *Db, opts MigrateOptions) error {
+	epochs, err := s.storedEpochs()
+	if err != nil {
+		return err
+	}
+	from := opts.FromEpoch
+	if from == 0 {
+		migrated, err := dst.storedEpochs()
+		if err != nil {
+			return err
+		}
+		if len(migrated) > 0 {
+			from = migrated[len(migrated)-1]
+		}
+	}
+
+	var throttle *tokenBucket
+	if opts.MaxEventsPerSecond > 0 {
+		throttle = newTokenBucket(opts.MaxEventsPerSecond)
+	}
+
+	events := make(chan *internal.EventInfo, s.batch.events)
+	loaded := make(chan struct{})
+	go func() {
+		defer close(loaded)
+		dst.Load(events)
+	}()
+	defer func() {
+		close(events)
+		<-loaded
+	}()
+
+	dead := atomic.LoadInt64(&dst.deadLetters)
+	for _, epoch := range epochs {
+		if epoch < from {
+			continue
+		}
+
+		var (
+			written sync.WaitGroup
+			count   int
+		)
+		err = s.readOnce(func(ctx neo4j.Transaction) error {
+			cursor, err := search(ctx, `MATCH (e:Event %s)
+				RETURN e, [(e)-[:PARENT]->(p:Event) | p.id] AS edges
+				ORDER BY e.lamport, e.id`,
+				fields{"epoch": int64(epoch)},
+			)
+			if err != nil {
+				return err
+			}
+
+			for cursor.Next() {
+				info := readEventWithEdges(cursor.Record())
+				if dst.HasEvent(info.Event.ID()) {
+					continue
+				}
+				throttle.Wait()
+				written.Add(1)
+				info.Dispose = written.Done
+				events <- info
+				count++
+			}
+			return cursor.Err()
+		})
+		written.Wait()
+		if err != nil {
+			return err
+		}
+		if lost := atomic.LoadInt64(&dst.deadLetters) - dead; lost > 0 {
+			return fmt.Errorf("epoch %d: %d events are not written", epoch, lost)
+		}
+
+		s.Log.Info("epoch is migrated", "epoch", epoch, "events", count)
+		if opts.Progress != nil {
+			opts.Progress(epoch, count)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package neo4j
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// Options of Db. Zero value is the default behavior.
+type Options struct {
+	// ConnectRetry makes New wait until db is up.
+	ConnectRetry RetrySpec
+	// LabelPrefix is prepended to the node labels (e.g. "Mainnet" makes :MainnetEvent and :MainnetState),
+	// so several datasets coexist in one db.
+	LabelPrefix string
+	// ParentRelType is the type of event to parent relationship ("PARENT" by default),
+	// so the DAG fits the graph with other relationships or tooling which expects another name.
+	ParentRelType string
+	// MaxTraversalResults caps the events returned by the unbounded traversals like FindAncestors,
+	// zero means DefaultMaxTraversalResults, negative means no limit.
+	MaxTraversalResults int
+	// MaxEventsPerSecond throttles Load and LoadParallel, zero means no limit.
+	MaxEventsPerSecond int
+	// EdgeWriteMode of the PARENT edges written by Load and LoadParallel.
+	EdgeWriteMode EdgeWriteMode
+	// IdEncoding of the stored event ids, it can't be changed for an existing db.
+	IdEncoding IdEncoding
+	// RootLabel adds the :Root label to the events written by Load and LoadParallel which start
+	// the epoch of their creator: the event has no self-parent (seq 1) in the source data.
+	// It makes GetRoots a label scan, the events written without the option are not labeled.
+	RootLabel bool
+	// TrustedBulk makes New create a plain index of the event ids instead of the unique constraint,
+	// so the import of a single trusted dump into a new db is faster. Call EnsureConstraints after the import.
+	TrustedBulk bool
+	// LoadBatch is a number of events written by Load in one transaction, 1 or less means an event per transaction.
+	LoadBatch int
+	// LoadBatchDelay is a longest time Load batch waits to be filled (100ms by default).
+	LoadBatchDelay time.Duration
+	// MaxBatchBytes flushes Load batch which estimated size reaches it, so events with big payloads
+	// don't make oversized transactions. Zero means no limit.
+	MaxBatchBytes int
+	// CompressPayload gzips the transactions payload of the events written by Load and LoadParallel.
+	// GetEventPayload reads both the compressed and the plain payloads, so db may have them mixed.
+	CompressPayload bool
+	// CausalConsistency makes the reads wait until the preceding Db writes are visible,
+	// e.g. GetEvent of the event just written by Load. Reads from the cluster replicas may wait longer.
+	CausalConsistency bool
+	// DeadLetter gets the events which Load and LoadParallel fail to write after the retries
+	// with the error, e.g. to keep them for investigation. They are counted and logged anyway.
+	DeadLetter func(info *internal.EventInfo, err error)
+	// IndexProgress gets the states of the db indexes while New waits for them to be online
+	// (e.g. the indexes created on a big db are populated for minutes). They are logged by default.
+	IndexProgress func(indexes []IndexState)
+	// Tracer is an OpenTelemetry tracer of GetEvent, FindAncestors and the events written by Load,
+	// e.g. otel.Tracer("dagreader") of the global provider. Nil means no tracing.
+	Tracer trace.Tracer
+}
+
+// EdgeWriteMode is a way to write PARENT edges.
+type EdgeWriteMode int
+
+const (
+	// CreateFast creates edges unconditionally, so it requires a clean db:
+	// the edges of the events imported again are duplicated.
+	CreateFast EdgeWriteMode = iota
+	// MergeIdempotent creates edges only if they don't exist, it is slower,
+	// but safe for the resumed imports.
+	MergeIdempotent
+)
+
+func (m EdgeWriteMode) clause() string {
+	if m == MergeIdempotent {
+		return "MERGE"
+	}
+	return "CREATE"
+}
+
+// DefaultMaxTraversalResults is a traversal results limit by default.
+const DefaultMaxTraversalResults = 1000000
+
+func (opts Options) maxTraversalResults() int {
+	if opts.MaxTraversalResults == 0 {
+		return DefaultMaxTraversalResults
+	}
+	return opts.MaxTraversalResults
+}
+
+func (opts Options) throttle() *tokenBucket {
+	if opts.MaxEventsPerSecond <= 0 {
+		return nil
+	}
+	return newTokenBucket(opts.MaxEventsPerSecond)
+}
+
+func (opts Options) batchLimits() batchLimits {
+	limits := batchLimits{
+		events: opts.LoadBatch,
+		bytes:  opts.MaxBatchBytes,
+		delay:  opts.LoadBatchDelay,
+	}
+	if limits.events < 1 {
+		limits.events = 1
+	}
+	if limits.delay <= 0 {
+		limits.delay = defaultLoadBatchDelay
+	}
+	return limits
+}
+
+func (opts Options) bookmarks() *bookmarks {
+	if !opts.CausalConsistency {
+		return nil
+	}
+	return &bookmarks{}
+}
+
+// RetrySpec is a retry with exponential backoff.
+type RetrySpec struct {
+	// Timeout is a total time of retries, zero means no retry.
+	Timeout time.Duration
+	// Delay is a first delay between retries (1s by default), it is doubled each retry.
+	Delay time.Duration
+	// MaxDelay caps the delay between retries (30s by default).
+	MaxDelay time.Duration
+}
+
+// Do calls f until it succeeds or spec timeout is reached, returns the last f error.
+func (spec RetrySpec) Do(f func() error, onRetry func(err error, delay time.Duration)) error {
+	delay, maxDelay := spec.Delay, spec.MaxDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+	deadline := time.Now().Add(spec.Timeout)
+
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		onRetry(err, delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package neo4j
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// customPropertyPrefix namespaces the properties set by SetEventProperty,
+// so they never clobber the event fields.
+const customPropertyPrefix = "x_"
+
+var customPropertyKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// SetEventProperty sets custom property of event, e.g. a computed score.
+// Key is stored with the "x_" prefix, value is a bool, number, string or a list of them.
+func (s *Db) SetEventProperty(e hash.Event, key string, value interface{}) error {
+	prop, err := customProperty(key)
+	if err != nil {
+		return err
+	}
+	if !isPropertyValue(value) {
+		return fmt.Errorf("unsupported property %s value type %T", key, value)
+	}
+
+	_, err = s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) SET e.%s = %s RETURN e.id`,
+			fields{"id": s.ids.str(e)}, prop, valToString(value))
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		return nil, nil
+	})
+
+	return err
+}
+
+// GetEventProperty returns custom property of event set by SetEventProperty
+// and false if the property is not set.
+func (s *Db) GetEventProperty(e hash.Event, key string) (interface{}, bool, error) {
+	prop, err := customProperty(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.%s`,
+			fields{"id": s.ids.str(e)}, prop)
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		return cursor.Record().GetByIndex(0), nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	return res, res != nil, nil
+}
+
+func customProperty(key string) (string, error) {
+	if !customPropertyKey.MatchString(key) {
+		return "", fmt.Errorf("invalid property key %q", key)
+	}
+	return customPropertyPrefix + key, nil
+}
+
+func isPropertyValue(v interface{}) bool {
+	switch v.(type) {
+	case bool, string, int, int32, int64, uint32, float32, float64,
+		[]bool, []string, []int, []int64, []float64:
+		return true
+	default:
+		return false
+	}
+}
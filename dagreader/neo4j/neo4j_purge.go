@@ -0,0 +1,224 @@
+package neo4j
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// purgeBatch is a number of nodes deleted in one transaction.
+const purgeBatch = 10000
+
+// deleteEventsBatch is a number of listed events deleted in one transaction.
+const deleteEventsBatch = 1000
+
+// ErrNotConfirmed is returned by the destructive operations called without confirmation.
+var ErrNotConfirmed = errors.New("operation is not confirmed")
+
+// ErrCurrentEpoch is returned by DeleteEpoch of the latest epoch, which may be still imported.
+var ErrCurrentEpoch = errors.New("epoch is the current one")
+
+// PurgeAll deletes all the Db nodes with their edges and sets db up as new.
+// Only the nodes of Options.LabelPrefix are deleted. It does nothing unless confirm is true.
+func (s *Db) PurgeAll(confirm bool) error {
+	if !confirm {
+		return ErrNotConfirmed
+	}
+
+	for _, label := range []string{":Event", ":Block", ":State"} {
+		deleted, err := s.deleteAll(fmt.Sprintf(`MATCH (n%s)`, label))
+		if err != nil {
+			return err
+		}
+		s.Log.Info("purged", "label", label, "nodes", deleted)
+	}
+	s.cache.EventInfos.Purge()
+
+	// the empty db is of the current schema, the version is replaced at once so it is never missing
+	_, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		err := exec(ctx, `MATCH (s:Schema) DELETE s`)
+		if err != nil {
+			return nil, err
+		}
+		return nil, exec(ctx, `CREATE (s:Schema {version: %d})`, SchemaVersion)
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.setup()
+}
+
+// DeleteEvents deletes the events with their edges, e.g. the bad events found by validation,
+// and returns the number of deleted events. The events which are not in db are skipped.
+func (s *Db) DeleteEvents(es []hash.Event) (int64, error) {
+	var total int64
+	for len(es) > 0 {
+		n := deleteEventsBatch
+		if n > len(es) {
+			n = len(es)
+		}
+		batch := es[:n]
+		es = es[n:]
+
+		res, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+			cursor, err := search(ctx, `UNWIND %s AS id MATCH (e:Event {id: id}) DETACH DELETE e`,
+				valToString(s.ids.strs(batch)))
+			if err != nil {
+				return nil, err
+			}
+			summary, err := cursor.Consume()
+			if err != nil {
+				return nil, err
+			}
+			return summary.Counters().NodesDeleted(), nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(res.(int))
+
+		for _, e := range batch {
+			s.cache.EventInfos.Remove(e)
+		}
+	}
+
+	return total, nil
+}
+
+// DeleteEpochOptions of DeleteEpoch.
+type DeleteEpochOptions struct {
+	// Chunk is a number of events deleted in one transaction, zero means 10000.
+	Chunk int
+	// Force allows to delete the latest epoch in db, it may be still imported.
+	Force bool
+	// Progress gets the number of events deleted so far and the number of epoch events before the deletion.
+	Progress func(deleted, total int64)
+}
+
+// DeleteEpoch deletes events of epoch with their edges by chunks, each chunk is committed independently.
+// Epoch is marked as deleting until its last chunk, so the interrupted deletion is listed by
+// GetDeletingEpochs and is finished by DeleteEpoch again. It returns the number of deleted events.
+func (s *Db) DeleteEpoch(epoch idx.Epoch, opts DeleteEpochOptions) (int64, error) {
+	chunk := opts.Chunk
+	if chunk <= 0 {
+		chunk = purgeBatch
+	}
+
+	if !opts.Force {
+		current, err := s.count(`MATCH (e:Event) RETURN coalesce(max(e.epoch), 0)`)
+		if err != nil {
+			return 0, err
+		}
+		if idx.Epoch(current) == epoch {
+			return 0, ErrCurrentEpoch
+		}
+	}
+
+	epochField := fields{"epoch": int64(epoch)}
+	total, err := s.count(`MATCH (e:Event %s) RETURN count(e)`, epochField)
+	if err != nil {
+		return 0, err
+	}
+	_, err = s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `MERGE (s:State {id: "deleting", epoch: %d})`, int64(epoch))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	query := fmt.Sprintf(`MATCH (n:Event %s) WITH n LIMIT %d DETACH DELETE n`, epochField, chunk)
+	var deleted int64
+	for {
+		n, err := s.autoCommit(query)
+		if err != nil {
+			return deleted, err
+		}
+		if n == 0 {
+			break
+		}
+		deleted += int64(n)
+		if opts.Progress != nil {
+			opts.Progress(deleted, total)
+		}
+	}
+
+	for _, key := range s.cache.EventInfos.Keys() {
+		if key.(hash.Event).Epoch() == epoch {
+			s.cache.EventInfos.Remove(key)
+		}
+	}
+
+	_, err = s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `MATCH (s:State {id: "deleting", epoch: %d}) DELETE s`, int64(epoch))
+	})
+	return deleted, err
+}
+
+// GetDeletingEpochs returns the epochs which DeleteEpoch is interrupted, they are deleted partially.
+func (s *Db) GetDeletingEpochs() ([]idx.Epoch, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (s:State {id: "deleting"}) RETURN s.epoch AS epoch ORDER BY epoch`)
+		if err != nil {
+			return nil, err
+		}
+
+		var epochs []idx.Epoch
+		for cursor.Next() {
+			epochs = append(epochs, idx.Epoch(cursor.Record().GetByIndex(0).(int64)))
+		}
+		return epochs, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]idx.Epoch), nil
+}
+
+// deleteAll deletes the nodes n of match by batches, returns the number of deleted nodes.
+func (s *Db) deleteAll(match string) (int, error) {
+	query, repeat := s.dialect.batchedDelete(match, purgeBatch)
+	if !repeat {
+		return s.autoCommit(query)
+	}
+
+	var total int
+	for {
+		deleted, err := s.autoCommit(query)
+		if err != nil {
+			return total, err
+		}
+		if deleted == 0 {
+			return total, nil
+		}
+		total += deleted
+	}
+}
+
+// autoCommit runs the query in its own transaction, returns the number of deleted nodes.
+func (s *Db) autoCommit(query string) (int, error) {
+	s.busy.Add(1)
+	defer s.busy.Done()
+
+	session, err := s.session(neo4j.AccessModeWrite)
+	if err != nil {
+		return 0, err
+	}
+	defer session.Close()
+
+	log.Debug("cypher", "query", query)
+	cursor, err := session.Run(query, nil)
+	if err != nil {
+		return 0, err
+	}
+	summary, err := cursor.Consume()
+	if err != nil {
+		return 0, err
+	}
+	return summary.Counters().NodesDeleted(), nil
+}
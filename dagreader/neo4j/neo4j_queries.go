@@ -0,0 +1,462 @@
+package neo4j
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// GetEventsWithPayload returns events of epoch which carry transactions.
+func (s *Db) GetEventsWithPayload(epoch idx.Epoch) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) WHERE e.tx_hash <> %s RETURN e.id ORDER BY e.id`,
+			fields{"epoch": int64(epoch)},
+			valToString(inter.EmptyTxHash.Hex()),
+		)
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// GetEventRaw returns event node properties as they are stored, without unmarshaling.
+func (s *Db) GetEventRaw(e hash.Event) (map[string]interface{}, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e`, fields{
+			"id": s.ids.str(e),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		return cursor.Record().GetByIndex(0).(neo4j.Node).Props(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(map[string]interface{}), nil
+}
+
+// GetEventEpoch returns epoch of event and false if event is not found.
+// The stored property is read, so it is a check of event as well.
+func (s *Db) GetEventEpoch(e hash.Event) (idx.Epoch, bool, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.epoch`, fields{
+			"id": s.ids.str(e),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			return nil, cursor.Err()
+		}
+		return idx.Epoch(toInt64(cursor.Record().GetByIndex(0))), nil
+	})
+	if err != nil || res == nil {
+		return 0, false, err
+	}
+
+	return res.(idx.Epoch), true, nil
+}
+
+// GetEventPayload returns RLP of event transactions, it is empty if event has no transactions
+// or is written without payload.
+func (s *Db) GetEventPayload(e hash.Event) ([]byte, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.payload, e.payload_gzip`, fields{
+			"id": s.ids.str(e),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		rec := cursor.Record()
+		payload, _ := rec.GetByIndex(0).(string)
+		compressed, _ := rec.GetByIndex(1).(bool)
+		return decodePayload(payload, compressed)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]byte), nil
+}
+
+// LastImported returns the event written last by Load or LoadParallel and when it is written (by db clock),
+// so the stalled import is seen by the time not advancing. Events imported by the older versions have no time.
+func (s *Db) LastImported() (hash.Event, time.Time, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event) WHERE e.imported_at IS NOT NULL
+			RETURN e.id, e.imported_at ORDER BY e.imported_at DESC LIMIT 1`)
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		return cursor.Record().Values(), nil
+	})
+	if err != nil {
+		return hash.Event{}, time.Time{}, err
+	}
+
+	vals := res.([]interface{})
+	id, err := readEventId(vals[0])
+	if err != nil {
+		return hash.Event{}, time.Time{}, err
+	}
+	ms := toInt64(vals[1])
+	return id, time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), nil
+}
+
+// GetChildren returns events which refer to event as a parent.
+func (s *Db) GetChildren(e hash.Event) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)<-[:PARENT]-(c:Event) RETURN c.id ORDER BY c.id`, fields{
+			"id": s.ids.str(e),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// GetEventsByEpochAndCreator returns events of creator in epoch ordered by seq, it is the creator chain.
+func (s *Db) GetEventsByEpochAndCreator(epoch idx.Epoch, creator idx.ValidatorID) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.id ORDER BY e.seq, e.id`, fields{
+			"epoch":   int64(epoch),
+			"creator": int64(creator),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// TopologicalOrder returns ids of epoch events ordered by lamport and id, so parents go before children
+// and the order is the same for each call.
+func (s *Db) TopologicalOrder(epoch idx.Epoch) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.id ORDER BY e.lamport, e.id`, fields{
+			"epoch": int64(epoch),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// GetRoots returns the events of epoch which start the epoch of their creator (seq 1).
+// With Options.RootLabel they are found by the :Root label.
+func (s *Db) GetRoots(epoch idx.Epoch) ([]hash.Event, error) {
+	query := `MATCH (e:Event %s) WHERE e.seq = 1 RETURN e.id ORDER BY e.id`
+	if s.rootLabel {
+		query = `MATCH (e:Root %s) RETURN e.id ORDER BY e.id`
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, query, fields{
+			"epoch": int64(epoch),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// IterateByTime calls fn for each event of epoch ordered by creation time, the ties by id.
+// Events without creation time (not the opera ones) go last. The query is not retried.
+func (s *Db) IterateByTime(epoch idx.Epoch, fn func(*internal.EventInfo) error) error {
+	return s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			RETURN e, [(e)-[:PARENT]->(p:Event) | p.id] AS edges
+			ORDER BY e.creation_time, e.id`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			err = fn(readEventWithEdges(cursor.Record()))
+			if err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	})
+}
+
+// GetCreators returns the validators which created events in epoch.
+func (s *Db) GetCreators(epoch idx.Epoch) ([]idx.ValidatorID, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN DISTINCT e.creator AS c ORDER BY c`, fields{
+			"epoch": int64(epoch),
+		})
+		if err != nil {
+			return nil, err
+		}
+		var creators []idx.ValidatorID
+		for cursor.Next() {
+			creators = append(creators, idx.ValidatorID(cursor.Record().GetByIndex(0).(int64)))
+		}
+		return creators, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]idx.ValidatorID), nil
+}
+
+// GetRecentEvents returns up to n events of epoch with the highest lamport, newest first.
+func (s *Db) GetRecentEvents(epoch idx.Epoch, n int) ([]*internal.EventInfo, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid events number %d", n)
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			WITH e ORDER BY e.lamport DESC, e.id LIMIT %d
+			OPTIONAL MATCH (e)-[:PARENT]->(p:Event)
+			WITH e, collect(p.id) AS edges
+			RETURN e, edges ORDER BY e.lamport DESC, e.id`,
+			fields{"epoch": int64(epoch)},
+			n,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var events []*internal.EventInfo
+		for cursor.Next() {
+			events = append(events, readEventWithEdges(cursor.Record()))
+		}
+		return events, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]*internal.EventInfo), nil
+}
+
+// WarmCache loads up to n most recent events of the last epoch into the events cache,
+// they are the likely parents of the next events of resumed import.
+func (s *Db) WarmCache(n int) error {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event) RETURN max(e.epoch)`)
+		if err != nil {
+			return nil, err
+		}
+		var epoch interface{}
+		if cursor.Next() {
+			epoch = cursor.Record().GetByIndex(0)
+		}
+		return epoch, cursor.Err()
+	})
+	if err != nil || res == nil {
+		return err
+	}
+
+	events, err := s.GetRecentEvents(idx.Epoch(toInt64(res)), n)
+	if err != nil {
+		return err
+	}
+	for _, info := range events {
+		s.cache.EventInfos.Add(info.Event.ID(), info)
+	}
+	s.Log.Info("cache is warmed up", "events", len(events))
+	return nil
+}
+
+// FindCrossEpochEdges returns PARENT edges of epoch events to the events of another epoch,
+// they chain the epochs together.
+func (s *Db) FindCrossEpochEdges(epoch idx.Epoch) ([]EdgePair, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)-[:PARENT]->(p:Event) WHERE p.epoch <> e.epoch
+			RETURN e.id, p.id ORDER BY e.id, p.id`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var edges []EdgePair
+		for cursor.Next() {
+			edge, err := readEdgePair(cursor.Record())
+			if err != nil {
+				return nil, err
+			}
+			edges = append(edges, edge)
+		}
+		return edges, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]EdgePair), nil
+}
+
+// GetEpochBoundaryParents returns the events of the previous epoch which are parents of epoch events,
+// they anchor epoch to the previous one.
+func (s *Db) GetEpochBoundaryParents(epoch idx.Epoch) ([]hash.Event, error) {
+	if epoch == 0 {
+		return nil, nil
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)-[:PARENT]->(p:Event %s)
+			RETURN DISTINCT p.id AS id ORDER BY id`,
+			fields{"epoch": int64(epoch)},
+			fields{"epoch": int64(epoch - 1)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// GetEventWindow returns events of epoch with lamport in [lo, hi] ordered by lamport, so parents go first.
+// Events keep all their parents ids, including the ones of the parents out of the window.
+func (s *Db) GetEventWindow(epoch idx.Epoch, lo, hi idx.Lamport) ([]*internal.EventInfo, error) {
+	if lo > hi {
+		return nil, fmt.Errorf("invalid lamport window [%d, %d]", lo, hi)
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) WHERE e.lamport >= %d AND e.lamport <= %d
+			RETURN e, [(e)-[:PARENT]->(p:Event) | p.id] AS edges
+			ORDER BY e.lamport, e.id`,
+			fields{"epoch": int64(epoch)},
+			int64(lo),
+			int64(hi),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var events []*internal.EventInfo
+		for cursor.Next() {
+			events = append(events, readEventWithEdges(cursor.Record()))
+		}
+		return events, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]*internal.EventInfo), nil
+}
+
+// readEventWithEdges reads event from the record of node and its PARENT edges ids.
+func readEventWithEdges(rec neo4j.Record) *internal.EventInfo {
+	ff := fields(rec.GetByIndex(0).(neo4j.Node).Props())
+	withParents(ff, func() hash.Events {
+		return toEventIds(rec.GetByIndex(1))
+	})
+
+	info := new(internal.EventInfo)
+	unmarshal(ff, info)
+	return info
+}
+
+// EventContext is an event with its direct parents and children.
+type EventContext struct {
+	Event    *internal.EventInfo
+	Parents  hash.Events
+	Children hash.Events
+}
+
+// GetEventContext returns event with its parents and children read by one query.
+func (s *Db) GetEventContext(e hash.Event) (*EventContext, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			OPTIONAL MATCH (e)-[:PARENT]->(p:Event)
+			WITH e, collect(p.id) AS parents
+			OPTIONAL MATCH (e)<-[:PARENT]-(c:Event)
+			WITH e, parents, collect(c.id) AS children
+			RETURN e, parents, children`,
+			fields{"id": s.ids.str(e)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+
+		rec := cursor.Record()
+		return &EventContext{
+			Event:    readEventWithEdges(rec),
+			Parents:  toEventIds(rec.GetByIndex(1)),
+			Children: toEventIds(rec.GetByIndex(2)),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*EventContext), nil
+}
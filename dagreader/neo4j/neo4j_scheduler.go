@@ -0,0 +1,254 @@
+package neo4j
+
+import (
+	"sync"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// committedPruneMin is the committed events number which is kept without pruning.
+const committedPruneMin = 10000
+
+// scheduler dispatches event to workers only when all its parents are committed,
+// so the independent events are written concurrently and parents are always written before children.
+type scheduler struct {
+	exists func(hash.Event) bool
+
+	// committed are the events written by workers, the old ones are pruned and asked by exists then
+	committed map[hash.Event]struct{}
+	pruneAt   int
+	// pushed are the events not committed yet
+	pushed map[hash.Event]struct{}
+	// waiting are the events by their not committed parent
+	waiting map[hash.Event][]*internal.EventInfo
+	// missing is a number of not committed parents by waiting event
+	missing map[hash.Event]int
+
+	queue    []*internal.EventInfo
+	inflight int
+	closed   bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+// newScheduler uses exists to check the parents which are not pushed into scheduler.
+func newScheduler(exists func(hash.Event) bool) *scheduler {
+	s := &scheduler{
+		exists:    exists,
+		committed: make(map[hash.Event]struct{}),
+		pruneAt:   committedPruneMin,
+		pushed:    make(map[hash.Event]struct{}),
+		waiting:   make(map[hash.Event][]*internal.EventInfo),
+		missing:   make(map[hash.Event]int),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Push event to schedule. It is not safe to call concurrently.
+func (s *scheduler) Push(info *internal.EventInfo) {
+	id := info.Event.ID()
+
+	s.mu.Lock()
+	var unknown hash.Events
+	for _, p := range info.Event.Parents() {
+		if _, ok := s.committed[p]; ok {
+			continue
+		}
+		if _, ok := s.pushed[p]; ok {
+			continue
+		}
+		unknown = append(unknown, p)
+	}
+	s.mu.Unlock()
+
+	// only Push adds events, so unknown parents stay unknown while db is asked
+	var absent map[hash.Event]struct{}
+	for _, p := range unknown {
+		if s.exists(p) {
+			s.mu.Lock()
+			s.committed[p] = struct{}{}
+			s.mu.Unlock()
+			continue
+		}
+		if absent == nil {
+			absent = make(map[hash.Event]struct{}, len(unknown))
+		}
+		absent[p] = struct{}{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pushed[id] = struct{}{}
+	for _, p := range info.Event.Parents() {
+		// parent committed since the check above may be pruned already, so the not committed ones are looked for
+		_, inflight := s.pushed[p]
+		_, lost := absent[p]
+		if !inflight && !lost {
+			continue
+		}
+		s.waiting[p] = append(s.waiting[p], info)
+		s.missing[id]++
+	}
+	if s.missing[id] == 0 {
+		delete(s.missing, id)
+		s.enqueue(info)
+	}
+}
+
+// Close means there will be no more events.
+// The events which wait for never pushed parents are released then.
+func (s *scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+}
+
+// Next returns event which parents are committed, or false when all the events are done.
+func (s *scheduler) Next() (*internal.EventInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.queue) == 0 {
+		if s.closed && s.inflight == 0 {
+			if !s.releaseLost() {
+				return nil, false
+			}
+			continue
+		}
+		s.cond.Wait()
+	}
+
+	info := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	s.inflight++
+
+	return info, true
+}
+
+// Commit marks event as written and releases its children.
+func (s *scheduler) Commit(id hash.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inflight--
+	delete(s.pushed, id)
+	s.release(id)
+	if len(s.committed) >= s.pruneAt {
+		s.prune()
+	}
+	s.cond.Broadcast()
+}
+
+// prune forgets the committed events below the lowest not committed lamport of their epoch,
+// the events pushed later refer to them rarely, so exists is asked then.
+func (s *scheduler) prune() {
+	lowest := make(map[idx.Epoch]idx.Lamport)
+	for id := range s.pushed {
+		if l, ok := lowest[id.Epoch()]; !ok || id.Lamport() < l {
+			lowest[id.Epoch()] = id.Lamport()
+		}
+	}
+	for id := range s.committed {
+		if l, ok := lowest[id.Epoch()]; !ok || id.Lamport() < l {
+			delete(s.committed, id)
+		}
+	}
+
+	// the pruning cost is amortized by the growth of the rest
+	s.pruneAt = 2 * len(s.committed)
+	if s.pruneAt < committedPruneMin {
+		s.pruneAt = committedPruneMin
+	}
+}
+
+func (s *scheduler) release(id hash.Event) {
+	s.committed[id] = struct{}{}
+	for _, child := range s.waiting[id] {
+		cid := child.Event.ID()
+		s.missing[cid]--
+		if s.missing[cid] == 0 {
+			delete(s.missing, cid)
+			s.enqueue(child)
+		}
+	}
+	delete(s.waiting, id)
+}
+
+// releaseLost treats the parents which are never pushed as committed.
+func (s *scheduler) releaseLost() bool {
+	var lost hash.Events
+	for p := range s.waiting {
+		if _, ok := s.pushed[p]; !ok {
+			lost = append(lost, p)
+		}
+	}
+	for _, p := range lost {
+		s.release(p)
+	}
+	return len(lost) > 0
+}
+
+func (s *scheduler) enqueue(info *internal.EventInfo) {
+	s.queue = append(s.queue, info)
+	s.cond.Signal()
+}
+
+// blockTracker finds the last block to resume from:
+// all the events of the blocks before it are written.
+type blockTracker struct {
+	pending map[idx.Block]int
+	max     idx.Block
+	mu      sync.Mutex
+}
+
+func newBlockTracker(last idx.Block) *blockTracker {
+	return &blockTracker{
+		pending: make(map[idx.Block]int),
+		max:     last,
+	}
+}
+
+// Push event of block.
+func (t *blockTracker) Push(b idx.Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[b]++
+	if t.max < b {
+		t.max = b
+	}
+}
+
+// Commit written event of block.
+func (t *blockTracker) Commit(b idx.Block) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pending[b]--
+	if t.pending[b] <= 0 {
+		delete(t.pending, b)
+	}
+}
+
+// Safe returns the lowest block with not written events, or the max block if all are written.
+func (t *blockTracker) Safe() idx.Block {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	safe := t.max
+	for b := range t.pending {
+		if b < safe {
+			safe = b
+		}
+	}
+	return safe
+}
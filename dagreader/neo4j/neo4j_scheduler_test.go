@@ -0,0 +1,112 @@
+package neo4j
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+func TestSchedulerParentsFirst(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 50, 3000, 0)
+	// known is the part of DAG which is in db already
+	known := make(map[hash.Event]struct{})
+	for _, e := range events[:100] {
+		known[e.ID()] = struct{}{}
+	}
+	events = events[100:]
+	// event with parent which is never pushed
+	lost := &inter.MutableEventPayload{}
+	lost.SetParents(hash.Events{hash.FakeEvent()})
+	lostEv := &lost.Build().Event
+	events = append(events, lostEv)
+
+	rand.New(rand.NewSource(0)).Shuffle(len(events), func(i, j int) {
+		events[i], events[j] = events[j], events[i]
+	})
+
+	sched := newScheduler(func(e hash.Event) bool {
+		_, ok := known[e]
+		return ok
+	})
+
+	var (
+		written    = make(map[hash.Event]struct{})
+		violations hash.Events
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				info, ok := sched.Next()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				for _, p := range info.Event.Parents() {
+					_, isWritten := written[p]
+					_, isKnown := known[p]
+					if !isWritten && !isKnown && info.Event.ID() != lostEv.ID() {
+						violations = append(violations, info.Event.ID())
+					}
+				}
+				written[info.Event.ID()] = struct{}{}
+				mu.Unlock()
+				sched.Commit(info.Event.ID())
+			}
+		}()
+	}
+
+	for _, e := range events {
+		sched.Push(&internal.EventInfo{Event: e})
+	}
+	sched.Close()
+	wg.Wait()
+
+	require.Empty(violations, "edge to not created node")
+	require.Len(written, len(events))
+}
+
+func TestSchedulerPrune(t *testing.T) {
+	require := require.New(t)
+
+	written := make(map[hash.Event]struct{})
+	sched := newScheduler(func(e hash.Event) bool {
+		_, ok := written[e]
+		return ok
+	})
+
+	events := SyntheticDAG(1, 5, 3*committedPruneMin, 0)
+	// event with parent which is never pushed stays not committed,
+	// so the committed events of its epoch above its lamport are kept
+	lost := &inter.MutableEventPayload{}
+	lost.SetEpoch(1)
+	lost.SetLamport(events[2*len(events)/3].Lamport())
+	lost.SetParents(hash.Events{hash.FakeEvent()})
+	lostEv := &lost.Build().Event
+	sched.Push(&internal.EventInfo{Event: lostEv})
+
+	for _, e := range events {
+		sched.Push(&internal.EventInfo{Event: e})
+		info, ok := sched.Next()
+		require.True(ok)
+		require.Equal(e.ID(), info.Event.ID())
+		written[e.ID()] = struct{}{}
+		sched.Commit(e.ID())
+	}
+
+	require.Less(len(sched.committed), len(events)/2)
+	for id := range sched.committed {
+		require.GreaterOrEqual(id.Lamport(), lostEv.Lamport())
+	}
+}
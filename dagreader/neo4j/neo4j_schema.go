@@ -0,0 +1,232 @@
+package neo4j
+
+import (
+	"fmt"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// SchemaVersion is the version of the stored data layout which this Db writes.
+const SchemaVersion = 2
+
+// legacySchemaVersion is the version of db with events written before the schema node was introduced.
+const legacySchemaVersion = 1
+
+// migrationBatch is a number of events updated in one migration transaction.
+const migrationBatch = 10000
+
+// migration brings db from the previous version to version.
+// Each of the queries updates up to migrationBatch events and is repeated until it updates nothing.
+type migration struct {
+	version int64
+	name    string
+	queries []string
+}
+
+var migrations = []migration{
+	{
+		version: 2,
+		name:    "parents and finalized properties",
+		queries: []string{
+			// edges don't keep the parents order, so ids of such events can't be recalculated from db anyway
+			`MATCH (e:Event) WHERE e.parents IS NULL
+				WITH e LIMIT %d
+				OPTIONAL MATCH (e)-[:PARENT]->(p:Event)
+				WITH e, collect(p.id) AS parents
+				SET e.parents = parents`,
+			`MATCH (e:Event) WHERE e.finalized IS NULL
+				WITH e LIMIT %d
+				SET e.finalized = false`,
+		},
+	},
+}
+
+// checkSchema refuses db of a newer schema and marks a new db with the current schema.
+func (s *Db) checkSchema() error {
+	version, err := s.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("db schema version %d is newer than supported %d", version, SchemaVersion)
+	}
+	if version < SchemaVersion {
+		s.Log.Warn("db schema is outdated, migrate it", "version", version, "current", SchemaVersion)
+	}
+	return s.checkIdEncoding()
+}
+
+// checkIdEncoding refuses db which events ids are stored in another encoding.
+func (s *Db) checkIdEncoding() error {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event) RETURN e.id LIMIT 1`)
+		if err != nil {
+			return nil, err
+		}
+		var id string
+		if cursor.Next() {
+			id = cursor.Record().GetByIndex(0).(string)
+		}
+		return id, cursor.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	if id := res.(string); id != "" && idEncodingOf(id) != s.ids {
+		return fmt.Errorf("db event ids are stored in another encoding: %s", id)
+	}
+	return nil
+}
+
+// GetSchemaVersion returns schema version of db. New db is marked with SchemaVersion.
+func (s *Db) GetSchemaVersion() (int64, error) {
+	res, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (s:Schema) RETURN s.version`)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Next() {
+			return cursor.Record().GetByIndex(0).(int64), cursor.Err()
+		}
+		if err = cursor.Err(); err != nil {
+			return nil, err
+		}
+
+		cursor, err = search(ctx, `MATCH (e:Event) RETURN e.id LIMIT 1`)
+		if err != nil {
+			return nil, err
+		}
+		var version int64 = SchemaVersion
+		if cursor.Next() {
+			version = legacySchemaVersion
+		}
+		if err = cursor.Err(); err != nil {
+			return nil, err
+		}
+
+		err = exec(ctx, `CREATE (s:Schema {version: %d})`, version)
+		if err != nil {
+			return nil, err
+		}
+		return version, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.(int64), nil
+}
+
+// readSchemaVersion returns schema version of db without marking it, 0 if db is not marked.
+// Unlike GetSchemaVersion it suits the read-only access.
+func (s *Db) readSchemaVersion() (int64, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (s:Schema) RETURN s.version`)
+		if err != nil {
+			return nil, err
+		}
+		var version int64
+		if cursor.Next() {
+			version = toInt64(cursor.Record().GetByIndex(0))
+		}
+		return version, cursor.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.(int64), nil
+}
+
+// Migrate applies the migrations to bring db up to SchemaVersion.
+func (s *Db) Migrate() error {
+	version, err := s.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("db schema version %d is newer than supported %d", version, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		s.Log.Info("migrate db", "version", m.version, "migration", m.name)
+		for _, query := range m.queries {
+			err = s.migrateAll(query)
+			if err != nil {
+				return fmt.Errorf("migration %d: %w", m.version, err)
+			}
+		}
+
+		_, err = s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+			return nil, exec(ctx, `MATCH (s:Schema) SET s.version = %d`, m.version)
+		})
+		if err != nil {
+			return err
+		}
+		version = m.version
+	}
+
+	return nil
+}
+
+// migrateAll repeats the batch query until it updates nothing.
+func (s *Db) migrateAll(query string) error {
+	for {
+		res, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+			cursor, err := search(ctx, query, migrationBatch)
+			if err != nil {
+				return nil, err
+			}
+			summary, err := cursor.Consume()
+			if err != nil {
+				return nil, err
+			}
+			return summary.Counters().PropertiesSet(), nil
+		})
+		if err != nil {
+			return err
+		}
+		if res.(int) == 0 {
+			return nil
+		}
+	}
+}
+
+// EnsureConstraints creates the unique constraint of the event ids, e.g. after Options.TrustedBulk import.
+// If there are duplicated events, it returns them with error and the constraint is not created.
+func (s *Db) EnsureConstraints() ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event) WITH e.id AS id, count(e) AS n WHERE n > 1 RETURN id ORDER BY id`)
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dups := res.(hash.Events); len(dups) > 0 {
+		return dups, fmt.Errorf("%d event ids are duplicated", len(dups))
+	}
+
+	// the constraint has its own index, which conflicts with the plain one
+	_, err = s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `DROP INDEX ON :Event(id)`)
+	})
+	if err != nil {
+		s.Log.Debug("no event id index", "err", err)
+	}
+
+	_, err = s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `CREATE CONSTRAINT ON (e:Event) ASSERT e.id IS UNIQUE`)
+	})
+	if err != nil && errorCode(err) != "Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists" {
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,168 @@
+package neo4j
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// ServerInfo is the Neo4j server version and the features which depend on it.
+type ServerInfo struct {
+	Version string
+	Edition string
+	// MultiDatabase is true for the servers which host several user databases.
+	MultiDatabase bool
+	// InTransactions is true for the servers which support CALL {...} IN TRANSACTIONS.
+	InTransactions bool
+	// ShowIndexes is true for the servers which support SHOW INDEXES.
+	ShowIndexes bool
+	// ShowProcedures is true for the servers which support SHOW PROCEDURES.
+	ShowProcedures bool
+}
+
+// ServerInfo returns the Neo4j server version and edition.
+func (s *Db) ServerInfo() (*ServerInfo, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `CALL dbms.components() YIELD name, versions, edition
+			WHERE name = "Neo4j Kernel" RETURN versions[0], edition`)
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("no Neo4j Kernel component")
+		}
+		rec := cursor.Record()
+		return newServerInfo(rec.GetByIndex(0).(string), rec.GetByIndex(1).(string))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*ServerInfo), nil
+}
+
+func newServerInfo(version, edition string) (*ServerInfo, error) {
+	major, minor, err := parseServerVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServerInfo{
+		Version:        version,
+		Edition:        edition,
+		MultiDatabase:  major >= 4 && edition == "enterprise",
+		InTransactions: major > 4 || (major == 4 && minor >= 4),
+		ShowIndexes:    major > 4 || (major == 4 && minor >= 2),
+		ShowProcedures: major > 4 || (major == 4 && minor >= 3),
+	}, nil
+}
+
+// parseServerVersion parses major and minor of version like "4.1.3" or "5.0.0-drop09.0".
+func parseServerVersion(version string) (major, minor int, err error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid server version %q", version)
+	}
+	major, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid server version %q", version)
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid server version %q", version)
+	}
+	return major, minor, nil
+}
+
+// dialect is the Cypher which differs by server version.
+type dialect struct {
+	inTransactions bool
+	showIndexes    bool
+	// procedures of the server, nil if they are unknown
+	procedures map[string]bool
+}
+
+func newDialect(info *ServerInfo) dialect {
+	return dialect{
+		inTransactions: info.InTransactions,
+		showIndexes:    info.ShowIndexes,
+	}
+}
+
+// serverDialect returns dialect of the connected server, it falls back to the oldest one.
+func (s *Db) serverDialect() dialect {
+	info, err := s.ServerInfo()
+	if err != nil {
+		s.Log.Warn("server version is unknown", "err", err)
+		return dialect{}
+	}
+	d := newDialect(info)
+
+	d.procedures, err = s.serverProcedures(info.ShowProcedures)
+	if err != nil {
+		s.Log.Warn("server procedures are unknown", "err", err)
+	}
+	return d
+}
+
+// serverProcedures returns the names of the procedures the server has.
+func (s *Db) serverProcedures(show bool) (map[string]bool, error) {
+	query := "CALL dbms.procedures() YIELD name RETURN name"
+	if show {
+		query = "SHOW PROCEDURES YIELD name RETURN name"
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		procedures := make(map[string]bool)
+		for cursor.Next() {
+			procedures[cursor.Record().GetByIndex(0).(string)] = true
+		}
+		return procedures, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(map[string]bool), nil
+}
+
+// HasProcedure returns true if the server has the procedure, e.g. "apoc.periodic.iterate".
+// If the procedures are not known, it is assumed to exist.
+func (s *Db) HasProcedure(name string) bool {
+	return s.dialect.has(name)
+}
+
+func (d dialect) has(procedure string) bool {
+	return d.procedures == nil || d.procedures[procedure]
+}
+
+// batchedDelete returns query which detach deletes the nodes n of match by batches of size.
+// The query must run in an auto-commit transaction. If repeat is true, the query deletes one batch
+// and has to be repeated until it deletes nothing.
+func (d dialect) batchedDelete(match string, size int) (query string, repeat bool) {
+	if d.inTransactions {
+		return fmt.Sprintf("%s CALL { WITH n DETACH DELETE n } IN TRANSACTIONS OF %d ROWS", match, size), false
+	}
+	return fmt.Sprintf("%s WITH n LIMIT %d DETACH DELETE n", match, size), true
+}
+
+// indexes returns query of the index name, state and population percent.
+func (d dialect) indexes() (string, error) {
+	if d.showIndexes {
+		return "SHOW INDEXES YIELD name, state, populationPercent RETURN name, state, populationPercent ORDER BY name", nil
+	}
+	if !d.has("db.indexes") {
+		return "", ErrUnsupported
+	}
+	return "CALL db.indexes() YIELD name, state, populationPercent RETURN name, state, populationPercent ORDER BY name", nil
+}
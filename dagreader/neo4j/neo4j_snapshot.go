@@ -0,0 +1,149 @@
+package neo4j
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+const (
+	snapshotMagic   = "lachesis-dag-snapshot"
+	snapshotVersion = 1
+)
+
+// snapshotHeader starts the snapshot stream.
+type snapshotHeader struct {
+	Magic   string
+	Version uint
+}
+
+// snapshotRecord is either event or the last block state.
+type snapshotRecord struct {
+	Event     *snapshotEvent
+	LastBlock *int64
+}
+
+type snapshotEvent struct {
+	Props fields
+	// Edges are the parents which PARENT edges exist
+	Edges []string
+}
+
+func init() {
+	// the neo4j property value types
+	gob.Register([]interface{}{})
+}
+
+// Snapshot writes all the events, their edges and the last block into w.
+// It is a gob stream: header, then records ordered by epoch and lamport, so parents go first.
+func (s *Db) Snapshot(w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	err := enc.Encode(snapshotHeader{
+		Magic:   snapshotMagic,
+		Version: snapshotVersion,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event)
+			OPTIONAL MATCH (e)-[:PARENT]->(p:Event)
+			WITH e, collect(p.id) AS edges
+			RETURN e, edges ORDER BY e.epoch, e.lamport, e.id`)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			rec := cursor.Record()
+			event := &snapshotEvent{
+				Props: rec.GetByIndex(0).(neo4j.Node).Props(),
+			}
+			for _, p := range rec.GetByIndex(1).([]interface{}) {
+				event.Edges = append(event.Edges, p.(string))
+			}
+			err = enc.Encode(snapshotRecord{Event: event})
+			if err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	last := int64(s.GetLastBlock())
+	return enc.Encode(snapshotRecord{LastBlock: &last})
+}
+
+// Restore loads snapshot from r by Load. The events which are in db already are skipped, so restore is idempotent.
+// Only the event fields are restored with the edges: payloads, custom properties and finalized marks are not.
+func (s *Db) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+
+	var header snapshotHeader
+	err := dec.Decode(&header)
+	if err != nil {
+		return err
+	}
+	if header.Magic != snapshotMagic {
+		return fmt.Errorf("not a snapshot")
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+
+	var (
+		loader = s.newEventsLoader()
+		last   *int64
+	)
+	for {
+		var rec snapshotRecord
+		err = dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			loader.close()
+			return err
+		}
+
+		if rec.Event != nil {
+			loader.add(rec.Event.eventInfo())
+		}
+		if rec.LastBlock != nil {
+			last = rec.LastBlock
+		}
+	}
+	if err = loader.close(); err != nil {
+		return err
+	}
+
+	if last == nil {
+		return nil
+	}
+	return s.restoreLastBlock(*last)
+}
+
+// eventInfo of snapshot event, the edges are the parents of events stored before the parents property.
+func (e *snapshotEvent) eventInfo() *internal.EventInfo {
+	withParents(e.Props, func() hash.Events {
+		return strs2eventIds(e.Edges)
+	})
+	return readEvent(e.Props)
+}
+
+func (s *Db) restoreLastBlock(last int64) error {
+	_, err := s.writeTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return nil, exec(ctx, `MERGE (s:State %s) SET s.block = %d`, fields{"id": "last"}, last)
+	})
+
+	return err
+}
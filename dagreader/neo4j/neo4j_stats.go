@@ -0,0 +1,336 @@
+package neo4j
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// descendantsDepthLimit bounds the PARENT path length used to count descendants.
+// Paths number grows exponentially with the length, so it has to be small.
+const descendantsDepthLimit = 3
+
+// EventRank is an event with its descendants count.
+type EventRank struct {
+	Event       hash.Event
+	Descendants int64
+}
+
+// TopByDescendantCount returns n events of epoch with the most descendants.
+// Full descendants counting is too expensive for a big epoch, so it is approximated:
+// only descendants of the same epoch within descendantsDepthLimit hops are counted,
+// it is a local centrality rather than the whole causal future of event.
+func (s *Db) TopByDescendantCount(epoch idx.Epoch, n int) ([]EventRank, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid events number %d", n)
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			OPTIONAL MATCH (d:Event %s)-[:PARENT*1..%d]->(e)
+			WITH e, count(DISTINCT d) AS descendants
+			RETURN e.id, descendants ORDER BY descendants DESC, e.id LIMIT %d`,
+			fields{"epoch": int64(epoch)},
+			fields{"epoch": int64(epoch)},
+			descendantsDepthLimit,
+			n,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var top []EventRank
+		for cursor.Next() {
+			rec := cursor.Record()
+			e, err := readEventId(rec.GetByIndex(0))
+			if err != nil {
+				return nil, err
+			}
+			top = append(top, EventRank{
+				Event:       e,
+				Descendants: rec.GetByIndex(1).(int64),
+			})
+		}
+		return top, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]EventRank), nil
+}
+
+// GetCurrentFrame returns the max frame of epoch events, 0 for empty epoch.
+func (s *Db) GetCurrentFrame(epoch idx.Epoch) (idx.Frame, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN max(e.frame)`, fields{
+			"epoch": int64(epoch),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var frame idx.Frame
+		if cursor.Next() {
+			if max, ok := cursor.Record().GetByIndex(0).(int64); ok {
+				frame = idx.Frame(max)
+			}
+		}
+		return frame, cursor.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.(idx.Frame), nil
+}
+
+// maxRatePoints bounds the EventRateTimeSeries length, the gaps filling would allocate
+// a point per bucket between the first and the last events otherwise.
+const maxRatePoints = 100000
+
+// RatePoint is a number of events created in the interval starting at Time.
+type RatePoint struct {
+	Time  time.Time
+	Count int64
+}
+
+// EventRateTimeSeries returns the numbers of epoch events by their creation time intervals of bucket.
+// Intervals between the first and the last events have a point even if there are no events,
+// it is an error if there are more than maxRatePoints of them, a bigger bucket has to be used then.
+func (s *Db) EventRateTimeSeries(epoch idx.Epoch, bucket time.Duration) ([]RatePoint, error) {
+	if bucket <= 0 {
+		return nil, fmt.Errorf("invalid bucket %s", bucket)
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) WHERE e.creation_time IS NOT NULL
+			WITH e.creation_time - e.creation_time %% %d AS t
+			RETURN t, count(*) ORDER BY t`,
+			fields{"epoch": int64(epoch)},
+			int64(bucket),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var points []RatePoint
+		for cursor.Next() {
+			rec := cursor.Record()
+			points = append(points, RatePoint{
+				Time:  time.Unix(0, rec.GetByIndex(0).(int64)),
+				Count: rec.GetByIndex(1).(int64),
+			})
+		}
+		return points, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fillRateGaps(res.([]RatePoint), bucket)
+}
+
+// fillRateGaps adds zero points for the empty intervals between the sorted points.
+func fillRateGaps(points []RatePoint, bucket time.Duration) ([]RatePoint, error) {
+	if len(points) == 0 {
+		return points, nil
+	}
+	span := points[len(points)-1].Time.Sub(points[0].Time)
+	if span/bucket >= maxRatePoints {
+		return nil, fmt.Errorf("bucket %s is too small for %s, more than %d points", bucket, span, maxRatePoints)
+	}
+
+	filled := make([]RatePoint, 0, len(points))
+	for _, p := range points {
+		if len(filled) > 0 {
+			for t := filled[len(filled)-1].Time.Add(bucket); t.Before(p.Time); t = t.Add(bucket) {
+				filled = append(filled, RatePoint{Time: t})
+			}
+		}
+		filled = append(filled, p)
+	}
+	return filled, nil
+}
+
+// CountEvents returns the number of events in db.
+func (s *Db) CountEvents() (int64, error) {
+	return s.count(`MATCH (e:Event) RETURN count(e)`)
+}
+
+// CountEdges returns the number of PARENT edges in db.
+func (s *Db) CountEdges() (int64, error) {
+	return s.count(`MATCH (:Event)-[r:PARENT]->() RETURN count(r)`)
+}
+
+// CountEpochEdges returns the number of PARENT edges of epoch events.
+func (s *Db) CountEpochEdges(epoch idx.Epoch) (int64, error) {
+	return s.count(`MATCH (e:Event %s)-[r:PARENT]->() RETURN count(r)`, fields{
+		"epoch": int64(epoch),
+	})
+}
+
+// EventDegree returns the number of events which refer to event as a parent (in) and the number of its parents (out).
+func (s *Db) EventDegree(e hash.Event) (in int64, out int64, err error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			RETURN size([(e)<-[:PARENT]-(c:Event) | c]), size([(e)-[:PARENT]->(p:Event) | p])`,
+			fields{"id": s.ids.str(e)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Next() {
+			if err = cursor.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		rec := cursor.Record()
+		return [2]int64{toInt64(rec.GetByIndex(0)), toInt64(rec.GetByIndex(1))}, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	degree := res.([2]int64)
+	return degree[0], degree[1], nil
+}
+
+// count runs the query returning a single number.
+func (s *Db) count(cypher string, a ...interface{}) (int64, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, cypher, a...)
+		if err != nil {
+			return nil, err
+		}
+
+		var n int64
+		if cursor.Next() {
+			n = cursor.Record().GetByIndex(0).(int64)
+		}
+		return n, cursor.Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.(int64), nil
+}
+
+// CreatorInteractionMatrix returns the numbers of events by creator A which have a parent by creator B,
+// by the ordered pairs {A, B} of epoch validators.
+func (s *Db) CreatorInteractionMatrix(epoch idx.Epoch) (map[[2]idx.ValidatorID]int64, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (a:Event %s)-[:PARENT]->(b:Event %s)
+			RETURN a.creator, b.creator, count(*)`,
+			fields{"epoch": int64(epoch)},
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		matrix := make(map[[2]idx.ValidatorID]int64)
+		for cursor.Next() {
+			rec := cursor.Record()
+			pair := [2]idx.ValidatorID{
+				idx.ValidatorID(rec.GetByIndex(0).(int64)),
+				idx.ValidatorID(rec.GetByIndex(1).(int64)),
+			}
+			matrix[pair] = rec.GetByIndex(2).(int64)
+		}
+		return matrix, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(map[[2]idx.ValidatorID]int64), nil
+}
+
+// EventsPerFrame returns the numbers of epoch events by frame.
+// Frames of very different sizes indicate the consensus stalls.
+func (s *Db) EventsPerFrame(epoch idx.Epoch) (map[idx.Frame]int64, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s) RETURN e.frame, count(*)`,
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		frames := make(map[idx.Frame]int64)
+		for cursor.Next() {
+			rec := cursor.Record()
+			frames[idx.Frame(toInt64(rec.GetByIndex(0)))] = rec.GetByIndex(1).(int64)
+		}
+		return frames, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(map[idx.Frame]int64), nil
+}
+
+// MaxDepth returns the number of edges of the longest PARENT path within epoch, from a tip down to a root.
+// The epoch events with their parents are streamed once in lamport order (parents go first),
+// so the longest path is found in O(events + edges) time, but the depths are held in memory:
+// it is a few dozen bytes per epoch event.
+func (s *Db) MaxDepth(epoch idx.Epoch) (int64, error) {
+	depths := newDepths()
+	err := s.readOnce(func(ctx neo4j.Transaction) error {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			OPTIONAL MATCH (e)-[:PARENT]->(p:Event %s)
+			WITH e, collect(p.id) AS parents
+			RETURN e.id, parents ORDER BY e.lamport, e.id`,
+			fields{"epoch": int64(epoch)},
+			fields{"epoch": int64(epoch)},
+		)
+		if err != nil {
+			return err
+		}
+
+		for cursor.Next() {
+			rec := cursor.Record()
+			e, err := readEventId(rec.GetByIndex(0))
+			if err != nil {
+				return err
+			}
+			depths.add(e, toEventIds(rec.GetByIndex(1)))
+		}
+		return cursor.Err()
+	})
+
+	return depths.max, err
+}
+
+// depths are the longest paths from events down to the roots.
+type depths struct {
+	byID map[hash.Event]int64
+	max  int64
+}
+
+func newDepths() *depths {
+	return &depths{
+		byID: make(map[hash.Event]int64),
+	}
+}
+
+// add event after its parents.
+func (d *depths) add(e hash.Event, parents hash.Events) {
+	var depth int64
+	for _, p := range parents {
+		if pd, ok := d.byID[p]; ok && pd+1 > depth {
+			depth = pd + 1
+		}
+	}
+	d.byID[e] = depth
+	if d.max < depth {
+		d.max = depth
+	}
+}
@@ -0,0 +1,179 @@
+package neo4j
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// neighborhoodNodesLimit caps the nodes number of neighborhood graph.
+const neighborhoodNodesLimit = 500
+
+// EdgePair is a PARENT edge.
+type EdgePair struct {
+	Child  hash.Event
+	Parent hash.Event
+}
+
+// SubgraphNode is a node of Subgraph.
+type SubgraphNode struct {
+	ID      hash.Event
+	Creator idx.ValidatorID
+}
+
+// Subgraph is a part of DAG.
+type Subgraph struct {
+	Nodes []SubgraphNode
+	Edges []EdgePair
+}
+
+// ExtractSubgraph returns events within hops PARENT edges (any direction) from center
+// and the edges among them. Nodes number is capped by limit.
+// Paths number grows exponentially with hops, so keep it small (a few hops).
+func (s *Db) ExtractSubgraph(center hash.Event, hops, limit int) (*Subgraph, error) {
+	if hops < 0 || limit <= 0 {
+		return nil, fmt.Errorf("invalid subgraph size: hops %d, limit %d", hops, limit)
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (c:Event %s)-[:PARENT*0..%d]-(n:Event) RETURN DISTINCT n.id, n.creator LIMIT %d`,
+			fields{"id": s.ids.str(center)},
+			hops,
+			limit,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sub := &Subgraph{}
+		var ids []string
+		for cursor.Next() {
+			rec := cursor.Record()
+			id := rec.GetByIndex(0).(string)
+			e, err := str2eventId(id)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+			sub.Nodes = append(sub.Nodes, SubgraphNode{
+				ID:      e,
+				Creator: idx.ValidatorID(toInt64(rec.GetByIndex(1))),
+			})
+		}
+		if err = cursor.Err(); err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, ErrNotFound
+		}
+
+		cursor, err = search(ctx, `MATCH (a:Event)-[:PARENT]->(b:Event) WHERE a.id IN %s AND b.id IN %s RETURN a.id, b.id`,
+			valToString(ids),
+			valToString(ids),
+		)
+		if err != nil {
+			return nil, err
+		}
+		for cursor.Next() {
+			edge, err := readEdgePair(cursor.Record())
+			if err != nil {
+				return nil, err
+			}
+			sub.Edges = append(sub.Edges, edge)
+		}
+		return sub, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*Subgraph), nil
+}
+
+// GetEdgesFor returns the PARENT edges of events es by one query, they are ordered by child and parent.
+func (s *Db) GetEdgesFor(es []hash.Event) ([]EdgePair, error) {
+	if len(es) == 0 {
+		return nil, nil
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `UNWIND %s AS id MATCH (e:Event {id: id})-[:PARENT]->(p:Event)
+			RETURN DISTINCT e.id AS child, p.id AS parent ORDER BY child, parent`,
+			valToString(s.ids.strs(es)),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var edges []EdgePair
+		for cursor.Next() {
+			edge, err := readEdgePair(cursor.Record())
+			if err != nil {
+				return nil, err
+			}
+			edges = append(edges, edge)
+		}
+		return edges, cursor.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.([]EdgePair), nil
+}
+
+// GetEventNeighborhoodJSON returns neighborhood of event in the d3-force/vis.js shape:
+// {"nodes":[{"id","creator","group"}], "links":[{"source","target"}]}.
+// If event is not found it returns {"error": ...} JSON and ErrNotFound.
+func (s *Db) GetEventNeighborhoodJSON(e hash.Event, hops int) ([]byte, error) {
+	sub, err := s.ExtractSubgraph(e, hops, neighborhoodNodesLimit)
+	if err == ErrNotFound {
+		bb, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return bb, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return neighborhoodJSON(sub)
+}
+
+type jsonNode struct {
+	ID      string `json:"id"`
+	Creator uint32 `json:"creator"`
+	Group   uint32 `json:"group"`
+}
+
+type jsonLink struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+func neighborhoodJSON(sub *Subgraph) ([]byte, error) {
+	graph := struct {
+		Nodes []jsonNode `json:"nodes"`
+		Links []jsonLink `json:"links"`
+	}{
+		Nodes: make([]jsonNode, 0, len(sub.Nodes)),
+		Links: make([]jsonLink, 0, len(sub.Edges)),
+	}
+
+	for _, n := range sub.Nodes {
+		graph.Nodes = append(graph.Nodes, jsonNode{
+			ID:      eventId2str(n.ID),
+			Creator: uint32(n.Creator),
+			Group:   uint32(n.Creator),
+		})
+	}
+	for _, e := range sub.Edges {
+		graph.Links = append(graph.Links, jsonLink{
+			Source: eventId2str(e.Child),
+			Target: eventId2str(e.Parent),
+		})
+	}
+
+	return json.Marshal(graph)
+}
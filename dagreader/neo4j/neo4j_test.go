@@ -1,11 +1,35 @@
 package neo4j
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/logger"
 	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/dag"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
 )
@@ -29,6 +53,1012 @@ func TestNeo4jMarshaling(t *testing.T) {
 	require.Equal(info0, info1)
 }
 
+func TestNeo4jMarshalingRoundTrip(t *testing.T) {
+	require := require.New(t)
+	r := rand.New(rand.NewSource(0))
+
+	for i := 0; i < 1000; i++ {
+		info0 := &internal.EventInfo{
+			Block: idx.Block(r.Uint64()),
+			Role:  []string{"", "atropos", "atropos*"}[r.Intn(3)],
+			Event: randEvent(r),
+		}
+		ff := marshal(info0)
+
+		info1 := &internal.EventInfo{}
+		unmarshal(ff, info1)
+
+		require.Equal(info0, info1, i)
+		require.Equal(info0.Event.ID(), info1.Event.ID(), i)
+		require.Equal(info0.Event.(inter.EventI).TxHash(), info1.Event.(inter.EventI).TxHash(), i)
+	}
+}
+
+func TestPayloadMarshaling(t *testing.T) {
+	require := require.New(t)
+
+	txs := types.Transactions{
+		types.NewTransaction(1, common.Address{1}, big.NewInt(10), 21000, big.NewInt(1), nil),
+		types.NewTransaction(2, common.Address{2}, big.NewInt(20), 21000, big.NewInt(1), []byte{1, 2}),
+	}
+	event := &inter.MutableEventPayload{}
+	event.SetTxs(txs)
+
+	ff := marshal(&internal.EventInfo{Event: event.Build()})
+	bb, err := base64.StdEncoding.DecodeString(ff["payload"].(string))
+	require.NoError(err)
+	var got types.Transactions
+	require.NoError(rlp.DecodeBytes(bb, &got))
+	require.Len(got, len(txs))
+	for i := range txs {
+		require.Equal(txs[i].Hash(), got[i].Hash())
+	}
+
+	s := &Db{compressPayload: true}
+	ff = s.marshal(&internal.EventInfo{Event: event.Build()})
+	require.Equal(true, ff["payload_gzip"])
+	compressed, err := decodePayload(ff["payload"].(string), true)
+	require.NoError(err)
+	require.Equal(bb, compressed)
+	_, err = decodePayload(ff["payload"].(string), false)
+	require.NoError(err)
+	_, err = decodePayload(base64.StdEncoding.EncodeToString(bb), true)
+	require.Error(err)
+
+	ff = marshal(&internal.EventInfo{Event: &event.Build().Event})
+	require.NotContains(ff, "payload")
+	ff = s.marshal(&internal.EventInfo{Event: &event.Build().Event})
+	require.NotContains(ff, "payload")
+	require.NotContains(ff, "payload_gzip")
+}
+
+func TestGetEventParents(t *testing.T) {
+	require := require.New(t)
+
+	r := rand.New(rand.NewSource(1))
+	info := &internal.EventInfo{Event: randEvent(r)}
+	require.NotEmpty(info.Event.Parents())
+
+	// node without edges
+	ff := marshal(info)
+	withParents(ff, func() hash.Events {
+		require.FailNow("edges are read")
+		return nil
+	})
+	got := new(internal.EventInfo)
+	unmarshal(ff, got)
+	require.Equal(info.Event.Parents(), got.Event.Parents())
+	require.Equal(info.Event.ID(), got.Event.ID())
+
+	// node without parents property
+	ff = marshal(info)
+	delete(ff, "parents")
+	withParents(ff, func() hash.Events {
+		return info.Event.Parents()
+	})
+	got = new(internal.EventInfo)
+	unmarshal(ff, got)
+	require.Equal(info.Event.ID(), got.Event.ID())
+}
+
+func TestGetEventCached(t *testing.T) {
+	require := require.New(t)
+
+	cache, err := lru.New(10)
+	require.NoError(err)
+	s := &Db{}
+	s.cache.EventInfos = cache
+
+	// as Load caches it
+	info := &internal.EventInfo{Event: SyntheticDAG(1, 1, 1, 1)[0]}
+	s.cache.EventInfos.Add(info.Event.ID(), info)
+
+	require.True(s.HasEvent(info.Event.ID()))
+	got, err := s.GetEvent(info.Event.ID())
+	require.NoError(err)
+	require.Equal(info, got)
+}
+
+func TestNeo4jMarshalingTxHash(t *testing.T) {
+	require := require.New(t)
+
+	// GetEventsWithPayload relies on empty tx_hash value
+	empty := &inter.MutableEventPayload{}
+	ff := marshal(&internal.EventInfo{Event: &empty.Build().Event})
+	require.Equal(inter.EmptyTxHash.Hex(), ff["tx_hash"])
+
+	heavy := &inter.MutableEventPayload{}
+	heavy.SetTxHash(hash.Hash(hash.FakeHash()))
+	ff = marshal(&internal.EventInfo{Event: &heavy.Build().Event})
+	require.NotEqual(inter.EmptyTxHash.Hex(), ff["tx_hash"])
+}
+
+func randEvent(r *rand.Rand) dag.Event {
+	e := &inter.MutableEventPayload{}
+	e.SetEpoch(idx.Epoch(r.Uint32()))
+	e.SetLamport(idx.Lamport(r.Uint32()))
+	e.SetCreator(idx.ValidatorID(r.Uint32()))
+	e.SetSeq(idx.Event(r.Uint32()))
+	e.SetFrame(idx.Frame(r.Uint32()))
+	e.SetCreationTime(inter.Timestamp(r.Uint64()))
+	e.SetMedianTime(inter.Timestamp(r.Uint64()))
+	e.SetGasPowerUsed(r.Uint64())
+	e.SetGasPowerLeft(inter.GasPowerLeft{Gas: [2]uint64{r.Uint64(), r.Uint64()}})
+
+	parents := make(hash.Events, r.Intn(10))
+	for i := range parents {
+		p := dag.MutableBaseEvent{}
+		p.SetEpoch(e.Epoch())
+		p.SetLamport(idx.Lamport(r.Int63n(int64(e.Lamport()) + 1)))
+		var tail [24]byte
+		r.Read(tail[:])
+		p.SetID(tail)
+		parents[i] = p.ID()
+	}
+	if len(parents) > 0 {
+		e.SetParents(parents)
+	}
+
+	if r.Intn(2) == 0 {
+		var h hash.Hash
+		r.Read(h[:])
+		e.SetTxHash(h)
+	}
+	if r.Intn(2) == 0 {
+		var h hash.Hash
+		r.Read(h[:])
+		e.SetPrevEpochHash(&h)
+	}
+	if n := r.Intn(3); n > 0 {
+		extra := make([]byte, n)
+		r.Read(extra)
+		e.SetExtra(extra)
+	}
+
+	return &e.Build().Event
+}
+
+func TestSyntheticDAG(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 5, 500, 0)
+	require.Len(events, 500)
+
+	seen := make(map[hash.Event]*inter.Event, len(events))
+	for _, e := range events {
+		for _, p := range e.Parents() {
+			parent, ok := seen[p]
+			require.True(ok, "parent goes before child")
+			require.Less(uint32(parent.Lamport()), uint32(e.Lamport()))
+		}
+		seen[e.ID()] = e
+	}
+	require.Len(seen, len(events))
+}
+
+func TestDeriveFrames(t *testing.T) {
+	require := require.New(t)
+
+	synthetic := SyntheticDAG(1, 4, 500, 1)
+	events := make([]dag.Event, len(synthetic))
+	for i, e := range synthetic {
+		events[i] = e
+	}
+
+	frames, err := deriveFrames(events)
+	require.NoError(err)
+	require.Len(frames, len(events))
+
+	var max idx.Frame
+	for _, e := range events {
+		f := frames[e.ID()]
+		if sp := e.SelfParent(); sp != nil {
+			require.GreaterOrEqual(uint32(f), uint32(frames[*sp]))
+		} else {
+			require.Equal(idx.Frame(1), f)
+		}
+		if max < f {
+			max = f
+		}
+	}
+	require.Greater(uint32(max), uint32(1))
+
+	_, err = deriveFrames(events[len(events)/2:])
+	require.Error(err, "parents are missing")
+}
+
+func TestFillRateGaps(t *testing.T) {
+	require := require.New(t)
+
+	t0 := time.Unix(0, 0)
+	points, err := fillRateGaps([]RatePoint{
+		{Time: t0, Count: 2},
+		{Time: t0.Add(3 * time.Second), Count: 1},
+		{Time: t0.Add(4 * time.Second), Count: 5},
+	}, time.Second)
+	require.NoError(err)
+	require.Equal([]RatePoint{
+		{Time: t0, Count: 2},
+		{Time: t0.Add(1 * time.Second)},
+		{Time: t0.Add(2 * time.Second)},
+		{Time: t0.Add(3 * time.Second), Count: 1},
+		{Time: t0.Add(4 * time.Second), Count: 5},
+	}, points)
+
+	points, err = fillRateGaps(nil, time.Second)
+	require.NoError(err)
+	require.Empty(points)
+
+	// maxRatePoints points are allowed
+	points, err = fillRateGaps([]RatePoint{
+		{Time: t0, Count: 1},
+		{Time: t0.Add((maxRatePoints - 1) * time.Second), Count: 1},
+	}, time.Second)
+	require.NoError(err)
+	require.Len(points, maxRatePoints)
+
+	_, err = fillRateGaps([]RatePoint{
+		{Time: t0, Count: 1},
+		{Time: t0.Add(365 * 24 * time.Hour), Count: 1},
+	}, time.Second)
+	require.Error(err)
+}
+
+func TestSeqGaps(t *testing.T) {
+	require.Empty(t, seqGaps(nil))
+	require.Empty(t, seqGaps([]idx.Event{1, 2, 3}))
+	require.Equal(t, []idx.Event{2, 4, 5}, seqGaps([]idx.Event{1, 3, 6}))
+
+	require.Empty(t, epochGaps([]idx.Epoch{42}))
+	require.Equal(t, []idx.Epoch{43}, epochGaps([]idx.Epoch{42, 44}))
+}
+
+func TestNeighborhoodJSON(t *testing.T) {
+	require := require.New(t)
+
+	a, b := hash.FakeEvent(), hash.FakeEvent()
+	bb, err := neighborhoodJSON(&Subgraph{
+		Nodes: []SubgraphNode{{ID: a, Creator: 1}, {ID: b, Creator: 2}},
+		Edges: []EdgePair{{Child: a, Parent: b}},
+	})
+	require.NoError(err)
+	require.JSONEq(`{
+		"nodes": [
+			{"id": "`+eventId2str(a)+`", "creator": 1, "group": 1},
+			{"id": "`+eventId2str(b)+`", "creator": 2, "group": 2}
+		],
+		"links": [
+			{"source": "`+eventId2str(a)+`", "target": "`+eventId2str(b)+`"}
+		]
+	}`, string(bb))
+}
+
+func TestRetrySpec(t *testing.T) {
+	require := require.New(t)
+
+	spec := RetrySpec{
+		Timeout:  50 * time.Millisecond,
+		Delay:    time.Millisecond,
+		MaxDelay: 4 * time.Millisecond,
+	}
+
+	calls := 0
+	err := spec.Do(func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("not ready")
+		}
+		return nil
+	}, func(error, time.Duration) {})
+	require.NoError(err)
+	require.Equal(3, calls)
+
+	var delays []time.Duration
+	err = spec.Do(func() error {
+		return errors.New("down")
+	}, func(_ error, d time.Duration) {
+		delays = append(delays, d)
+	})
+	require.EqualError(err, "down")
+	ms := time.Millisecond
+	require.Equal([]time.Duration{1 * ms, 2 * ms, 4 * ms, 4 * ms}, delays[:4])
+
+	calls = 0
+	err = RetrySpec{}.Do(func() error {
+		calls++
+		return errors.New("down")
+	}, func(error, time.Duration) {})
+	require.Error(err)
+	require.Equal(1, calls)
+}
+
+func TestTraversalLimit(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(DefaultMaxTraversalResults, Options{}.maxTraversalResults())
+	require.Equal(10, Options{MaxTraversalResults: 10}.maxTraversalResults())
+	require.Equal(-1, Options{MaxTraversalResults: -1}.maxTraversalResults())
+
+	var err error = &TraversalTooLargeError{Partial: 10}
+	require.True(errors.Is(err, ErrTraversalTooLarge))
+	require.EqualError(err, "traversal result is too large: more than 10 events")
+}
+
+func TestCustomProperty(t *testing.T) {
+	require := require.New(t)
+
+	prop, err := customProperty("score")
+	require.NoError(err)
+	require.Equal("x_score", prop)
+
+	for _, key := range []string{"", "1st", "a b", "a}", "a.b"} {
+		_, err = customProperty(key)
+		require.Error(err, key)
+	}
+
+	require.True(isPropertyValue(0.5))
+	require.True(isPropertyValue([]string{"a"}))
+	require.False(isPropertyValue(map[string]int{}))
+}
+
+func TestPlanFormat(t *testing.T) {
+	plan := &planNode{
+		Operator:    "ProduceResults",
+		Identifiers: []string{"e"},
+		Profiled:    true,
+		DbHits:      0,
+		Records:     2,
+		Children: []*planNode{{
+			Operator:    "NodeIndexSeek",
+			Identifiers: []string{"e"},
+			Arguments:   map[string]interface{}{"Details": "e:Event(epoch)"},
+			Profiled:    true,
+			DbHits:      3,
+			Records:     2,
+		}},
+	}
+
+	var out strings.Builder
+	plan.format(&out, 0)
+	require.Equal(t, "ProduceResults (e) dbHits=0 rows=2\n"+
+		"  NodeIndexSeek (e) dbHits=3 rows=2 e:Event(epoch)\n", out.String())
+}
+
+func TestTokenBucket(t *testing.T) {
+	require := require.New(t)
+
+	require.Nil(Options{}.throttle())
+
+	b := Options{MaxEventsPerSecond: 10}.throttle()
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		require.Zero(b.take(now), "burst")
+	}
+	require.Equal(100*time.Millisecond, b.take(now))
+	require.Equal(200*time.Millisecond, b.take(now))
+
+	// the debt is paid
+	now = now.Add(200 * time.Millisecond)
+	require.Equal(100*time.Millisecond, b.take(now))
+
+	// the burst is capped
+	now = now.Add(time.Hour)
+	for i := 0; i < 10; i++ {
+		require.Zero(b.take(now))
+	}
+	require.NotZero(b.take(now))
+}
+
+func TestEdgeWriteMode(t *testing.T) {
+	require.Equal(t, "CREATE", Options{}.EdgeWriteMode.clause())
+	require.Equal(t, "MERGE", MergeIdempotent.clause())
+}
+
+func TestExportFilterEdges(t *testing.T) {
+	exported := map[string]struct{}{"a": {}, "b": {}}
+	require.True(t, isExported(exported, &exportEdge{Child: "a", Parent: "b"}))
+	require.False(t, isExported(exported, &exportEdge{Child: "a", Parent: "c"}))
+	require.False(t, isExported(exported, &exportEdge{Child: "c", Parent: "b"}))
+}
+
+// testTracer records the spans, it is an OpenTelemetry tracer.
+type testTracer struct {
+	spans []*testSpan
+}
+
+type testSpan struct {
+	trace.Span
+	operation string
+	attrs     map[string]interface{}
+	err       error
+	ended     bool
+}
+
+func (t *testTracer) Start(ctx context.Context, operation string, _ ...trace.SpanOption) (context.Context, trace.Span) {
+	span := &testSpan{operation: operation, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *testSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[string(a.Key)] = a.Value.AsInterface()
+	}
+}
+
+func (s *testSpan) RecordError(err error, _ ...trace.EventOption) {
+	s.err = err
+}
+
+func (s *testSpan) SetStatus(codes.Code, string) {}
+
+func (s *testSpan) End(...trace.SpanOption) {
+	s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(dbSpan{}, (&Db{}).span("GetEvent"))
+
+	tracer := &testTracer{}
+	cache, err := lru.New(10)
+	require.NoError(err)
+	s := &Db{tracer: tracer}
+	s.cache.EventInfos = cache
+
+	info := &internal.EventInfo{Event: SyntheticDAG(1, 1, 1, 1)[0]}
+	s.cache.EventInfos.Add(info.Event.ID(), info)
+	_, err = s.GetEvent(info.Event.ID())
+	require.NoError(err)
+
+	require.Len(tracer.spans, 1)
+	span := tracer.spans[0]
+	require.Equal("GetEvent", span.operation)
+	require.Equal(info.Event.ID().String(), span.attrs["event"])
+	require.Equal(true, span.attrs["cached"])
+	require.True(span.ended)
+	require.NoError(span.err)
+
+	failed := errors.New("failed")
+	s.span("Load").End(failed)
+	require.Equal(failed, tracer.spans[1].err)
+	require.True(tracer.spans[1].ended)
+}
+
+func TestEventsBatch(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(batchLimits{events: 1, delay: defaultLoadBatchDelay}, Options{}.batchLimits())
+
+	info := &internal.EventInfo{}
+	batch := newEventsBatch(batchLimits{events: 3, bytes: 10})
+	require.True(batch.empty())
+	require.False(batch.add(info, "abc"))
+	require.False(batch.add(info, "def"))
+	require.True(batch.add(info, "g"), "events limit")
+
+	batch.reset()
+	require.True(batch.empty())
+	require.False(batch.add(info, "abc"))
+	require.True(batch.add(info, "0123456"), "bytes limit")
+}
+
+func TestEventsBatchOrder(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 5, 50, 1)
+	r := rand.New(rand.NewSource(1))
+	shuffled := make([]*inter.Event, len(events))
+	for i, j := range r.Perm(len(events)) {
+		shuffled[i] = events[j]
+	}
+
+	order := func(events []*inter.Event) []hash.Event {
+		batch := newEventsBatch(batchLimits{events: len(events)})
+		for _, e := range events {
+			batch.add(&internal.EventInfo{Event: e}, e.ID().String())
+		}
+		sort.Sort(batch)
+
+		ids := make([]hash.Event, len(batch.infos))
+		for i, info := range batch.infos {
+			ids[i] = info.Event.ID()
+			require.Equal(ids[i].String(), batch.data[i])
+		}
+		return ids
+	}
+
+	expect := order(events)
+	require.Equal(expect, order(shuffled))
+	for i := 1; i < len(expect); i++ {
+		require.True(expect[i-1].Lamport() <= expect[i].Lamport())
+	}
+}
+
+func TestServerInfo(t *testing.T) {
+	require := require.New(t)
+
+	info, err := newServerInfo("4.1.3", "community")
+	require.NoError(err)
+	require.Equal(&ServerInfo{Version: "4.1.3", Edition: "community"}, info)
+
+	info, err = newServerInfo("4.4.0", "enterprise")
+	require.NoError(err)
+	require.True(info.MultiDatabase)
+	require.True(info.InTransactions)
+
+	info, err = newServerInfo("5.0.0-drop09.0", "community")
+	require.NoError(err)
+	require.False(info.MultiDatabase)
+	require.True(info.InTransactions)
+
+	_, err = newServerInfo("dev", "community")
+	require.Error(err)
+}
+
+func TestDialectBatchedDelete(t *testing.T) {
+	require := require.New(t)
+
+	query, repeat := dialect{}.batchedDelete("MATCH (n:Event)", 100)
+	require.True(repeat)
+	require.Equal("MATCH (n:Event) WITH n LIMIT 100 DETACH DELETE n", query)
+
+	info, err := newServerInfo("4.4.0", "community")
+	require.NoError(err)
+	query, repeat = newDialect(info).batchedDelete("MATCH (n:Event)", 100)
+	require.False(repeat)
+	require.Equal("MATCH (n:Event) CALL { WITH n DETACH DELETE n } IN TRANSACTIONS OF 100 ROWS", query)
+}
+
+func TestIndexesOnline(t *testing.T) {
+	require := require.New(t)
+
+	online, err := indexesOnline(nil)
+	require.NoError(err)
+	require.True(online)
+
+	states := []IndexState{
+		{Name: "index_1", State: "ONLINE", Percent: 100},
+		{Name: "index_2", State: "POPULATING", Percent: 42},
+	}
+	online, err = indexesOnline(states)
+	require.NoError(err)
+	require.False(online)
+
+	states[1].State = "ONLINE"
+	online, err = indexesOnline(states)
+	require.NoError(err)
+	require.True(online)
+
+	states[0].State = "FAILED"
+	_, err = indexesOnline(states)
+	require.Error(err)
+
+	query, err := dialect{}.indexes()
+	require.NoError(err)
+	require.Contains(query, "CALL db.indexes()")
+	_, err = dialect{procedures: map[string]bool{"dbms.components": true}}.indexes()
+	require.Equal(ErrUnsupported, err)
+
+	info, err := newServerInfo("4.2.0", "community")
+	require.NoError(err)
+	require.True(info.ShowIndexes)
+	query, err = newDialect(info).indexes()
+	require.NoError(err)
+	require.Contains(query, "SHOW INDEXES")
+}
+
+func TestDialectProcedures(t *testing.T) {
+	require := require.New(t)
+
+	// unknown procedures are tried
+	require.True(dialect{}.has("apoc.periodic.iterate"))
+
+	d := dialect{procedures: map[string]bool{"db.indexes": true}}
+	require.True(d.has("db.indexes"))
+	require.False(d.has("apoc.periodic.iterate"))
+
+	info, err := newServerInfo("4.3.0", "community")
+	require.NoError(err)
+	require.True(info.ShowProcedures)
+	info, err = newServerInfo("4.1.3", "community")
+	require.NoError(err)
+	require.False(info.ShowProcedures)
+}
+
+// fakeSession runs work the way driver does: it commits the transaction after work.
+type fakeSession struct {
+	neo4j.Session
+	commitErr error
+	bookmark  string
+	queries   []string
+	runErr    error
+}
+
+type fakeTx struct {
+	neo4j.Transaction
+	session   *fakeSession
+	commitErr error
+	committed bool
+}
+
+// fakeDriver opens the same session always.
+type fakeDriver struct {
+	neo4j.Driver
+	session neo4j.Session
+}
+
+func (d *fakeDriver) Session(neo4j.AccessMode, ...string) (neo4j.Session, error) {
+	return d.session, nil
+}
+
+func (s *fakeSession) WriteTransaction(work neo4j.TransactionWork, _ ...func(*neo4j.TransactionConfig)) (interface{}, error) {
+	tx := &fakeTx{session: s, commitErr: s.commitErr}
+	res, err := work(tx)
+	if err != nil {
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+func (s *fakeSession) LastBookmark() string {
+	return s.bookmark
+}
+
+func (s *fakeSession) Close() error {
+	return nil
+}
+
+func (tx *fakeTx) Commit() error {
+	if tx.committed {
+		return errors.New("transaction is committed")
+	}
+	if tx.commitErr != nil {
+		return tx.commitErr
+	}
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Close() error {
+	return nil
+}
+
+// Run records the query, the result is not readable.
+func (tx *fakeTx) Run(cypher string, _ map[string]interface{}) (neo4j.Result, error) {
+	tx.session.queries = append(tx.session.queries, cypher)
+	return nil, tx.session.runErr
+}
+
+func TestCommitTx(t *testing.T) {
+	require := require.New(t)
+
+	noop := func(neo4j.Transaction) error { return nil }
+	require.NoError(commitTx(&fakeSession{}, noop))
+
+	violation := errors.New("constraint violation")
+	require.Equal(violation, commitTx(&fakeSession{commitErr: violation}, noop))
+	require.Equal(violation, commitTx(&fakeSession{}, func(neo4j.Transaction) error { return violation }))
+
+	var dead []error
+	s := &Db{
+		onDeadLetter: func(_ *internal.EventInfo, err error) {
+			dead = append(dead, err)
+		},
+		Instance: logger.New("neo4j"),
+	}
+	s.deadLetter(&internal.EventInfo{Event: SyntheticDAG(1, 1, 1, 1)[0]}, violation)
+	require.Equal([]error{violation}, dead)
+	require.Equal(int64(1), s.deadLetters)
+}
+
+func TestWriteFrames(t *testing.T) {
+	require := require.New(t)
+
+	session := &fakeSession{}
+	s := &Db{
+		drv:      &fakeDriver{session: session},
+		Instance: logger.New("neo4j"),
+	}
+
+	synthetic := SyntheticDAG(1, 3, framesBatch*2+1, 1)
+	changed := make([]dag.Event, len(synthetic))
+	frames := make(map[hash.Event]idx.Frame, len(synthetic))
+	for i, e := range synthetic {
+		changed[i] = e
+		frames[e.ID()] = e.Frame() + 1
+	}
+
+	require.NoError(s.writeFrames(changed, frames))
+	require.Len(session.queries, len(changed))
+	require.Contains(session.queries[len(changed)-1], fmt.Sprintf("SET e.frame = %d", frames[changed[len(changed)-1].ID()]))
+}
+
+func TestLoadEdgesDrained(t *testing.T) {
+	s := &Db{
+		drv:      &fakeDriver{session: &fakeSession{runErr: errors.New("db is down")}},
+		Instance: logger.New("neo4j"),
+	}
+
+	edges := make(chan [2]hash.Event)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		defer close(edges)
+		for i := 0; i < edgesBatch*3; i++ {
+			edges <- [2]hash.Event{hash.FakeEvent(), hash.FakeEvent()}
+		}
+	}()
+
+	require.Error(t, s.LoadEdges(edges))
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("producer is blocked")
+	}
+}
+
+func TestDriverTarget(t *testing.T) {
+	require := require.New(t)
+
+	for dbUrl, expect := range map[string]struct {
+		target    string
+		encrypted bool
+	}{
+		DefaultDb:                          {DefaultDb, false},
+		"neo4j://core:7687":                {"neo4j://core:7687", false},
+		"bolt+routing://core:7687?region=": {"bolt+routing://core:7687?region=", false},
+		"neo4j+s://core:7687":              {"neo4j://core:7687", true},
+		"bolt+ssc://localhost:7687":        {"bolt://localhost:7687", true},
+	} {
+		target, configure, err := driverTarget(dbUrl)
+		require.NoError(err, dbUrl)
+		require.Equal(expect.target, target, dbUrl)
+		c := &neo4j.Config{Encrypted: !expect.encrypted}
+		configure(c)
+		require.Equal(expect.encrypted, c.Encrypted, dbUrl)
+	}
+
+	for _, dbUrl := range []string{"http://localhost:7474", "bolt+x://localhost", "neo4j+routing://core"} {
+		_, _, err := driverTarget(dbUrl)
+		require.Error(err, dbUrl)
+	}
+}
+
+func TestBookmarks(t *testing.T) {
+	require := require.New(t)
+
+	b := &bookmarks{}
+	session := &bookmarkedSession{
+		Session:   &fakeSession{bookmark: "bm0"},
+		bookmarks: b,
+	}
+	require.NoError(commitTx(session, func(neo4j.Transaction) error { return nil }))
+	require.Equal([]string{"bm0"}, b.get())
+	require.NoError(session.Close())
+	require.Equal([]string{"bm0"}, b.get())
+
+	b.add("")
+	for i := 1; i <= bookmarksLimit; i++ {
+		b.add(fmt.Sprintf("bm%d", i))
+	}
+	recent := b.get()
+	require.Len(recent, bookmarksLimit)
+	require.Equal("bm1", recent[0])
+	require.Equal(fmt.Sprintf("bm%d", bookmarksLimit), recent[bookmarksLimit-1])
+}
+
+func TestEpochChecksum(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 3, 20, 1)
+	ordered := make([]eventParents, len(events))
+	shuffled := make([]eventParents, len(events))
+	for i, j := range rand.New(rand.NewSource(1)).Perm(len(events)) {
+		e := events[j]
+		ordered[j] = eventParents{e.ID(), e.Parents()}
+		parents := append(hash.Events(nil), e.Parents()...)
+		for k := len(parents) - 1; k > 0; k-- {
+			parents[0], parents[k] = parents[k], parents[0]
+		}
+		shuffled[i] = eventParents{e.ID(), parents}
+	}
+
+	sum := epochChecksum(ordered)
+	require.Equal(sum, epochChecksum(shuffled))
+	require.NotEqual(sum, epochChecksum(ordered[1:]))
+
+	last := len(ordered) - 1
+	require.NotEmpty(ordered[last].parents)
+	ordered[last].parents = ordered[last].parents[1:]
+	require.NotEqual(sum, epochChecksum(ordered))
+
+	empty := sha256.Sum256(nil)
+	require.Equal(empty, epochChecksum(nil))
+}
+
+func TestSwallowedErrorStats(t *testing.T) {
+	require := require.New(t)
+
+	s := &Db{}
+	require.Empty(s.SwallowedErrorStats())
+
+	schemaErr := errors.New("Server error: [Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists] exists")
+	require.Equal("Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists", errorCode(schemaErr))
+	s.ignoreFakeError(schemaErr)
+	s.ignoreFakeError(schemaErr)
+	s.ignoreFakeError(errors.New("connection reset"))
+
+	stats := s.SwallowedErrorStats()
+	require.Equal(map[string]int64{
+		"Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists": 2,
+		"*errors.errorString": 1,
+	}, stats)
+
+	// stats are a copy
+	stats["x"] = 1
+	require.Len(s.SwallowedErrorStats(), 2)
+}
+
+func TestDepths(t *testing.T) {
+	require := require.New(t)
+
+	d := newDepths()
+	for _, e := range SyntheticDAG(1, 1, 5, 1) {
+		d.add(e.ID(), e.Parents())
+	}
+	require.Equal(int64(4), d.max)
+
+	d = newDepths()
+	events := SyntheticDAG(1, 3, 30, 1)
+	for _, e := range events {
+		d.add(e.ID(), e.Parents())
+	}
+	require.True(d.max >= 30/3-1)
+	require.True(d.max < 30)
+}
+
+func TestSnapshotRecordEncoding(t *testing.T) {
+	require := require.New(t)
+
+	// the values as they come from neo4j driver
+	rec0 := snapshotRecord{Event: &snapshotEvent{
+		Props: fields{
+			"id":        eventId2str(hash.FakeEvent()),
+			"seq":       int64(3),
+			"finalized": false,
+			"parents":   []interface{}{eventId2str(hash.FakeEvent())},
+		},
+		Edges: []string{eventId2str(hash.FakeEvent())},
+	}}
+
+	buf := &bytes.Buffer{}
+	require.NoError(gob.NewEncoder(buf).Encode(rec0))
+
+	var rec1 snapshotRecord
+	require.NoError(gob.NewDecoder(buf).Decode(&rec1))
+	require.Equal(rec0, rec1)
+}
+
+func TestSnapshotEventInfo(t *testing.T) {
+	require := require.New(t)
+
+	for _, e := range SyntheticDAG(1, 3, 10, 1) {
+		props := marshal(&internal.EventInfo{Event: e})
+		edges := eventIds2strs(e.Parents())
+		// events stored before the parents property have the edges only
+		delete(props, "parents")
+
+		info := (&snapshotEvent{Props: props, Edges: edges}).eventInfo()
+		require.Equal(e.ID(), info.Event.ID())
+	}
+}
+
+func TestLabeler(t *testing.T) {
+	require := require.New(t)
+
+	require.Nil(newLabeler(Options{}))
+
+	l := newLabeler(Options{LabelPrefix: "Mainnet"})
+	require.Equal(
+		"MATCH (e:MainnetEvent {id:1})-[:PARENT]->(p:MainnetEvent), (s:MainnetState) CREATE INDEX ON :MainnetEvent(epoch)",
+		l.Replace("MATCH (e:Event {id:1})-[:PARENT]->(p:Event), (s:State) CREATE INDEX ON :Event(epoch)"),
+	)
+
+	require.Equal("CREATE (e:MainnetEvent:MainnetRoot {id:1})", l.Replace("CREATE (e:Event:Root {id:1})"))
+
+	// only the labels are replaced, not the strings and names
+	require.Equal(
+		`MATCH (e:MainnetEvent {id:"1:2:Event", role:'a\':State'}) RETURN e.id AS `+"`:Block`"+`, e:EventX`,
+		l.Replace(`MATCH (e:Event {id:"1:2:Event", role:'a\':State'}) RETURN e.id AS `+"`:Block`"+`, e:EventX`),
+	)
+	require.Equal(`MATCH (e:MainnetEvent {id:"1:2:Event`, l.Replace(`MATCH (e:Event {id:"1:2:Event`))
+
+	require.Nil(newLabeler(Options{ParentRelType: "PARENT"}))
+
+	l = newLabeler(Options{ParentRelType: "OBSERVES"})
+	require.Equal(
+		"MATCH (e:Event {id:1})-[:OBSERVES*0..]->(p:Event) MERGE (e)-[:OBSERVES]->(p)",
+		l.Replace("MATCH (e:Event {id:1})-[:PARENT*0..]->(p:Event) MERGE (e)-[:PARENT]->(p)"),
+	)
+
+	_, err := New(DefaultDb, Options{ParentRelType: "PARENT]->(x"})
+	require.Error(err)
+}
+
+func TestEventLabels(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 2, 10, 1)
+	var root, next *inter.Event
+	for _, e := range events {
+		if e.Seq() == 1 {
+			root = e
+		} else {
+			next = e
+		}
+	}
+
+	s := &Db{}
+	require.Equal(":Event", s.eventLabels(root))
+
+	s.rootLabel = true
+	require.Equal(":Event:Root", s.eventLabels(root))
+	require.Equal(":Event", s.eventLabels(next))
+}
+
+func TestIdEncoding(t *testing.T) {
+	require := require.New(t)
+
+	r := rand.New(rand.NewSource(1))
+	id := randEvent(r).ID()
+
+	hex := HexIds.str(id)
+	require.Equal(id.FullID(), hex)
+	require.Equal(HexIds, idEncodingOf(hex))
+	require.Equal(id, mustEventId(hex))
+
+	b64 := Base64Ids.str(id)
+	require.Equal(len(hex)-16, len(b64))
+	require.Equal(Base64Ids, idEncodingOf(b64))
+	require.Equal(id, mustEventId(b64))
+
+	s := &Db{ids: Base64Ids}
+	info := &internal.EventInfo{Event: randEvent(r)}
+	ff := s.marshal(info)
+	require.Equal(Base64Ids.str(info.Event.ID()), ff["id"])
+	got := new(internal.EventInfo)
+	unmarshal(ff, got)
+	require.Equal(info.Event.ID(), got.Event.ID())
+}
+
+// labelLikeEventId returns id which Base64Ids encoding contains :Event.
+func labelLikeEventId() hash.Event {
+	tail, err := base64.RawURLEncoding.DecodeString("EventEventEventEventEventEventEv")
+	if err != nil {
+		panic(err)
+	}
+	var id hash.Event
+	copy(id[:4], idx.Epoch(1).Bytes())
+	copy(id[4:8], idx.Lamport(2).Bytes())
+	copy(id[8:], tail)
+	return id
+}
+
+func TestBase64IdsLabeled(t *testing.T) {
+	require := require.New(t)
+
+	id := labelLikeEventId()
+	str := Base64Ids.str(id)
+	require.Contains(str, ":Event")
+
+	l := newLabeler(Options{LabelPrefix: "Mainnet"})
+	query := l.Replace(fmt.Sprintf(`MATCH (e:Event %s) RETURN e`, fields{"id": str}))
+	require.Equal(fmt.Sprintf(`MATCH (e:MainnetEvent {id:"%s"}) RETURN e`, str), query)
+	require.Equal(id, mustEventId(str))
+}
+
 func TestEventIdParsing(t *testing.T) {
 	require := require.New(t)
 	for i, e0 := range []hash.Event{
@@ -38,9 +1068,160 @@ func TestEventIdParsing(t *testing.T) {
 		hash.FakeEvent(),
 		hash.FakeEvent(),
 	} {
-		s := eventId2str(e0)
-		e1 := str2eventId(s)
+		for _, ids := range []IdEncoding{HexIds, Base64Ids} {
+			s := ids.str(e0)
+			e1, err := str2eventId(s)
+			require.NoError(err, s)
+
+			require.Equal(e0, e1, i, s)
+		}
+	}
+
+	id := hash.FakeEvent()
+	hex := HexIds.str(id)
+	b64 := Base64Ids.str(id)
+	for _, s := range []string{
+		"",
+		"1:2",
+		"x:1:" + hex[strings.LastIndex(hex, ":")+1:],
+		"1:-1:" + hex[strings.LastIndex(hex, ":")+1:],
+		"4294967296:1:" + hex[strings.LastIndex(hex, ":")+1:],
+		hex[:len(hex)-2],
+		hex[:len(hex)-1] + "z",
+		hex + "00",
+		b64[:len(b64)-1] + "!",
+		b64 + "AA",
+	} {
+		_, err := str2eventId(s)
+		require.Error(err, s)
+	}
 
-		require.Equal(e0, e1, i, s)
+	_, err := readEventId(nil)
+	require.Error(err)
+}
+
+// nextEpochEvent returns event of the next epoch which parent is in the epoch of parent.
+// Lamport restarts in the new epoch.
+func nextEpochEvent(parent *inter.Event) *inter.Event {
+	e := &inter.MutableEventPayload{}
+	e.SetEpoch(parent.Epoch() + 1)
+	e.SetCreator(parent.Creator())
+	e.SetSeq(1)
+	e.SetLamport(1)
+	e.SetCreationTime(parent.CreationTime() + 1)
+	e.SetMedianTime(parent.MedianTime() + 1)
+	e.SetParents(hash.Events{parent.ID()})
+	return &e.Build().Event
+}
+
+// testGraphML writes events as GraphML of ImportGraphML.
+func testGraphML(events []*inter.Event) string {
+	var names []string
+	for name := range graphMLAttrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var doc strings.Builder
+	doc.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, name := range names {
+		typ := "string"
+		if graphMLAttrs[name] == "long" {
+			typ = "long"
+		}
+		fmt.Fprintf(&doc, `<key id="%s" for="node" attr.name="%s" attr.type="%s"/>`+"\n", name, name, typ)
+	}
+	doc.WriteString(`<graph edgedefault="directed">` + "\n")
+	for _, e := range events {
+		ff := marshal(&internal.EventInfo{Event: e})
+		fmt.Fprintf(&doc, `<node id="%s">`, ff["id"])
+		for _, name := range names {
+			v, ok := ff[name]
+			if !ok {
+				continue
+			}
+			if graphMLAttrs[name] == "longs" {
+				bb, _ := json.Marshal(v)
+				v = string(bb)
+			}
+			fmt.Fprintf(&doc, `<data key="%s">%v</data>`, name, v)
+		}
+		doc.WriteString("</node>\n")
+	}
+	for _, e := range events {
+		for _, p := range e.Parents() {
+			fmt.Fprintf(&doc, `<edge source="%s" target="%s"/>`+"\n", eventId2str(e.ID()), eventId2str(p))
+		}
+	}
+	doc.WriteString("</graph>\n</graphml>\n")
+	return doc.String()
+}
+
+func TestParseGraphML(t *testing.T) {
+	require := require.New(t)
+
+	events := SyntheticDAG(1, 3, 20, 1)
+	events = append(events, nextEpochEvent(events[len(events)-1]))
+	doc := testGraphML(events)
+	parse := func(doc string, has func(hash.Event) bool) ([]*internal.EventInfo, error) {
+		var got []*internal.EventInfo
+		err := parseGraphML(strings.NewReader(doc), has, func(info *internal.EventInfo) {
+			got = append(got, info)
+		})
+		return got, err
+	}
+	none := func(hash.Event) bool { return false }
+	nodeOf := func(e *inter.Event) string {
+		start := strings.Index(doc, `<node id="`+eventId2str(e.ID()))
+		return doc[start : start+strings.Index(doc[start:], "</node>\n")+len("</node>\n")]
+	}
+
+	// edges may go before the nodes
+	nodesStart := strings.Index(doc, "<node ")
+	nodesEnd := strings.LastIndex(doc, "</node>\n") + len("</node>\n")
+	edgesFirst := doc[:nodesStart] + doc[nodesEnd:strings.Index(doc, "</graph>")] + doc[nodesStart:nodesEnd] + "</graph>\n</graphml>\n"
+	for _, doc := range []string{doc, edgesFirst} {
+		got, err := parse(doc, none)
+		require.NoError(err)
+		require.Len(got, len(events))
+		parents := make(map[hash.Event]hash.Events, len(got))
+		for _, info := range got {
+			// parents go first, the one of the previous epoch too
+			for _, p := range info.Event.Parents() {
+				require.Contains(parents, p)
+			}
+			parents[info.Event.ID()] = info.Event.Parents()
+		}
+		for _, e := range events {
+			require.Equal(e.Parents(), parents[e.ID()])
+		}
+	}
+
+	// parents out of the document are in db
+	first := events[0]
+	partial := strings.Replace(doc, nodeOf(first), "", 1)
+	_, err := parse(partial, none)
+	require.Error(err)
+	got, err := parse(partial, func(e hash.Event) bool { return e == first.ID() })
+	require.NoError(err)
+	require.Len(got, len(events)-1)
+
+	some := events[len(events)-2]
+	for name, malformed := range map[string]string{
+		"unknown attribute": strings.Replace(doc, `attr.name="frame"`, `attr.name="color"`, 1),
+		"wrong type":        strings.Replace(doc, `attr.name="seq" attr.type="long"`, `attr.name="seq" attr.type="string"`, 1),
+		"unknown key":       strings.Replace(doc, `<data key="seq">`, `<data key="d9">`, 1),
+		"bad value":         strings.Replace(doc, `<data key="creator">`, `<data key="creator">x`, 1),
+		"no attribute":      strings.Replace(doc, `key="lamport"`, `key="frame"`, 1),
+		"bad node id":       strings.Replace(doc, `<node id="`, `<node id="1:`, 1),
+		"duplicated node":   strings.Replace(doc, "</graph>", nodeOf(some)+"</graph>", 1),
+		"bad edge":          strings.Replace(doc, `<edge source="`, `<edge source="x`, 1),
+		"unknown child":     strings.Replace(doc, "</graph>", `<edge source="`+eventId2str(hash.FakeEvent())+`" target="`+eventId2str(some.ID())+`"/></graph>`, 1),
+		"extra edge":        strings.Replace(doc, "</graph>", `<edge source="`+eventId2str(some.ID())+`" target="`+eventId2str(first.ID())+`"/></graph>`, 1),
+		"lost edge":         strings.Replace(doc, `<edge source="`+eventId2str(some.ID())+`" target="`+eventId2str(some.Parents()[0])+`"/>`, "", 1),
+		"not xml":           doc[:len(doc)/2],
+	} {
+		_, err = parse(malformed, none)
+		require.Error(err, name)
 	}
 }
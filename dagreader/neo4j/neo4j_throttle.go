@@ -0,0 +1,52 @@
+package neo4j
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits rate of events, it allows a burst of one second of events.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func newTokenBucket(perSecond int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+	}
+}
+
+// Wait until the next event is allowed.
+func (b *tokenBucket) Wait() {
+	if b == nil {
+		return
+	}
+	if delay := b.take(time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// take a token at now and return the time to wait for it.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	// the debt is paid by the tokens refilled while waiting
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
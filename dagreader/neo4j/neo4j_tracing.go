@@ -0,0 +1,42 @@
+package neo4j
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// dbSpan is a traced db operation, it is no-op without the span.
+type dbSpan struct {
+	span trace.Span
+}
+
+func (s dbSpan) SetAttribute(key string, value interface{}) {
+	if s.span != nil {
+		s.span.SetAttributes(attribute.Any(key, value))
+	}
+}
+
+// End finishes span, err is the operation error or nil.
+func (s dbSpan) End(err error) {
+	if s.span == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// span starts span of operation, it is no-op if there is no tracer.
+// Db methods have no context, so the spans are the roots of their traces.
+func (s *Db) span(operation string) dbSpan {
+	if s.tracer == nil {
+		return dbSpan{}
+	}
+	_, span := s.tracer.Start(context.Background(), operation)
+	return dbSpan{span}
+}
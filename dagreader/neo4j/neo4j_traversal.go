@@ -0,0 +1,334 @@
+package neo4j
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// GetAncestorsWithinEpoch returns ancestors of event which are in the same epoch.
+// Parents are never of a later epoch, so the paths to them don't cross the epoch boundary
+// and only the ancestors are filtered, not the paths.
+// It returns TraversalTooLargeError if there are more than Options.MaxTraversalResults ancestors.
+func (s *Db) GetAncestorsWithinEpoch(e hash.Event) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return s.searchEventIdsLimited(ctx, `MATCH (p:Event %s)-[:PARENT*]->(s:Event %s)
+			RETURN DISTINCT s.id`,
+			fields{"id": s.ids.str(e)},
+			fields{"epoch": int64(e.Epoch())},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// bfsBatch is a number of frontier events which parents are read by one query of GetAncestorsBFS.
+const bfsBatch = 500
+
+// GetAncestorsBFS returns the same ancestors as FindAncestors, but it reads them hop by hop:
+// the parents of frontier batches are read by concurrent workers.
+// It may outperform the variable length traversal on the wide DAGs, the progress is logged per hop.
+// It returns TraversalTooLargeError if there are more than Options.MaxTraversalResults ancestors.
+func (s *Db) GetAncestorsBFS(e hash.Event, workers int) ([]hash.Event, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var (
+		visited   = make(map[hash.Event]struct{})
+		frontier  = hash.Events{e}
+		ancestors = hash.Events{}
+	)
+	for hop := 1; len(frontier) > 0; hop++ {
+		parents, err := s.parentsOf(frontier, workers)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = frontier[:0]
+		for _, p := range parents {
+			if _, ok := visited[p]; ok {
+				continue
+			}
+			visited[p] = struct{}{}
+			frontier = append(frontier, p)
+			ancestors = append(ancestors, p)
+		}
+		if s.maxTraversal > 0 && len(ancestors) > s.maxTraversal {
+			return nil, &TraversalTooLargeError{Partial: s.maxTraversal}
+		}
+		s.Log.Debug("ancestors hop", "event", e, "hop", hop, "new", len(frontier), "total", len(ancestors))
+	}
+
+	return ancestors, nil
+}
+
+// parentsOf reads parents of the events by batches of concurrent workers.
+func (s *Db) parentsOf(events hash.Events, workers int) (hash.Events, error) {
+	var (
+		batches = make(chan hash.Events)
+		wg      sync.WaitGroup
+
+		mu      sync.Mutex
+		parents hash.Events
+		failed  error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				mu.Lock()
+				skip := failed != nil
+				mu.Unlock()
+				if skip {
+					continue
+				}
+
+				res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+					cursor, err := search(ctx, `UNWIND %s AS id MATCH (:Event {id: id})-[:PARENT]->(p:Event) RETURN DISTINCT p.id`,
+						valToString(s.ids.strs(batch)))
+					if err != nil {
+						return nil, err
+					}
+					return readEventIds(cursor)
+				})
+
+				mu.Lock()
+				if err != nil {
+					if failed == nil {
+						failed = err
+					}
+				} else {
+					parents = append(parents, res.(hash.Events)...)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for len(events) > 0 {
+		n := bfsBatch
+		if n > len(events) {
+			n = len(events)
+		}
+		batches <- events[:n]
+		events = events[n:]
+	}
+	close(batches)
+	wg.Wait()
+
+	return parents, failed
+}
+
+// FindAncestorsPaged returns a page of up to limit ancestors of event after the continuation token,
+// empty after is for the first page. Ancestors are ordered by id, so the pages are stable.
+// Next is the token of the next page, it is empty for the last page.
+func (s *Db) FindAncestorsPaged(e hash.Event, after string, limit int) (ids []hash.Event, next string, err error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid page limit %d", limit)
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (p:Event %s)-[:PARENT*]->(s:Event)
+			WITH DISTINCT s.id AS id WHERE id > %s
+			RETURN id ORDER BY id LIMIT %d`,
+			fields{"id": s.ids.str(e)},
+			valToString(after),
+			limit+1,
+		)
+		if err != nil {
+			return nil, err
+		}
+		return readEventIds(cursor)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	ids = res.(hash.Events)
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = s.ids.str(ids[limit-1])
+	}
+	return ids, next, nil
+}
+
+// IsAncestor returns true if a is an ancestor of b.
+func (s *Db) IsAncestor(a, b hash.Event) (bool, error) {
+	// ancestor is of an earlier epoch or has a lower lamport in the same epoch, it is known from the ids
+	if a.Epoch() > b.Epoch() || a.Epoch() == b.Epoch() && a.Lamport() >= b.Lamport() {
+		return false, nil
+	}
+
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH path = shortestPath((b:Event %s)-[:PARENT*]->(a:Event %s)) RETURN count(path)`,
+			fields{"id": s.ids.str(b)},
+			fields{"id": s.ids.str(a)},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var found bool
+		if cursor.Next() {
+			found = cursor.Record().GetByIndex(0).(int64) > 0
+		}
+		return found, cursor.Err()
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return res.(bool), nil
+}
+
+// AreConcurrent returns true if neither of events is an ancestor of the other.
+func (s *Db) AreConcurrent(a, b hash.Event) (bool, error) {
+	if a == b {
+		return false, nil
+	}
+
+	ancestor, err := s.IsAncestor(a, b)
+	if err != nil || ancestor {
+		return false, err
+	}
+	ancestor, err = s.IsAncestor(b, a)
+	if err != nil {
+		return false, err
+	}
+	return !ancestor, nil
+}
+
+// EventsReachableFrom returns tips and their ancestors which are neither excluding events nor their ancestors:
+// the events one node sends to another one which knows the excluding tips.
+// It returns TraversalTooLargeError if there are more than Options.MaxTraversalResults events.
+func (s *Db) EventsReachableFrom(tips []hash.Event, excluding []hash.Event) ([]hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		return s.searchEventIdsLimited(ctx, `OPTIONAL MATCH (x:Event)-[:PARENT*0..]->(b:Event) WHERE x.id IN %s
+			WITH collect(DISTINCT b) AS known
+			MATCH (t:Event)-[:PARENT*0..]->(a:Event) WHERE t.id IN %s AND NOT a IN known
+			RETURN DISTINCT a.id`,
+			valToString(s.ids.strs(excluding)),
+			valToString(s.ids.strs(tips)),
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(hash.Events), nil
+}
+
+// TraceToGenesis returns the self-parents chain of event down to the earliest creator event in db,
+// the chain is continued from the last creator event of the previous stored epoch.
+// Reached is true if the chain ends with the creator first event: seq 1 of the first epoch
+// or of epoch which previous epoch is stored. Otherwise the earlier events are not in db.
+func (s *Db) TraceToGenesis(e hash.Event) (chain []hash.Event, reached bool, err error) {
+	head := e
+	for {
+		var part hash.Events
+		var tailSeq int64
+		part, tailSeq, err = s.selfParentChain(head)
+		if err != nil {
+			return
+		}
+		if len(part) == 0 {
+			if len(chain) == 0 {
+				err = ErrNotFound
+			}
+			return
+		}
+		chain = append(chain, part...)
+		tail := part[len(part)-1]
+
+		var prev *hash.Event
+		prev, err = s.lastCreatorEventBefore(tail)
+		if err != nil {
+			return
+		}
+		if prev == nil {
+			if tailSeq == 1 {
+				reached, err = s.isFirstCreatorEpoch(tail.Epoch())
+			}
+			return
+		}
+		head = *prev
+	}
+}
+
+// selfParentChain returns event and its self-parents of the same epoch, with the seq of the earliest one.
+func (s *Db) selfParentChain(e hash.Event) (hash.Events, int64, error) {
+	type chainRes struct {
+		ids hash.Events
+		seq int64
+	}
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH path = (e:Event %s)-[:PARENT*0..]->(g:Event)
+			WHERE all(n IN nodes(path) WHERE n.creator = e.creator)
+			AND NOT (g)-[:PARENT]->(:Event {creator: e.creator})
+			RETURN [n IN nodes(path) | n.id], g.seq
+			ORDER BY length(path) DESC LIMIT 1`,
+			fields{"id": s.ids.str(e)},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		var r chainRes
+		if cursor.Next() {
+			rec := cursor.Record()
+			r.ids = toEventIds(rec.GetByIndex(0))
+			r.seq = rec.GetByIndex(1).(int64)
+		}
+		return r, cursor.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := res.(chainRes)
+	return r.ids, r.seq, nil
+}
+
+// lastCreatorEventBefore returns the last event of the same creator in the previous epochs, nil if there is no.
+func (s *Db) lastCreatorEventBefore(e hash.Event) (*hash.Event, error) {
+	res, err := s.readTx(func(ctx neo4j.Transaction) (interface{}, error) {
+		cursor, err := search(ctx, `MATCH (e:Event %s)
+			MATCH (c:Event {creator: e.creator}) WHERE c.epoch < e.epoch
+			RETURN c.id ORDER BY c.epoch DESC, c.seq DESC LIMIT 1`,
+			fields{"id": s.ids.str(e)},
+		)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := readEventIds(cursor)
+		if err != nil || len(ids) == 0 {
+			return (*hash.Event)(nil), err
+		}
+		return &ids[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*hash.Event), nil
+}
+
+// isFirstCreatorEpoch returns true if there can't be earlier creator events than in epoch:
+// it is the first epoch or the previous epoch is stored.
+func (s *Db) isFirstCreatorEpoch(epoch idx.Epoch) (bool, error) {
+	if epoch <= 1 {
+		return true, nil
+	}
+	n, err := s.count(`MATCH (e:Event %s) RETURN count(e)`, fields{
+		"epoch": int64(epoch - 1),
+	})
+	return n > 0, err
+}
@@ -0,0 +1,52 @@
+package neo4j
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/neo4j/neo4j-go-driver/neo4j"
+)
+
+// driverTarget returns the driver url and config of db url.
+// Supported schemes are bolt (single server), neo4j and bolt+routing (cluster: reads are routed
+// to the replicas, writes to the leader) and their +s (encrypted) and +ssc (encrypted, self-signed
+// certificate) variants, e.g. neo4j+s://.
+func driverTarget(dbUrl string) (target string, configure func(*neo4j.Config), err error) {
+	parsed, err := url.Parse(dbUrl)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scheme, security := parsed.Scheme, ""
+	if i := strings.LastIndex(scheme, "+"); i >= 0 && scheme != "bolt+routing" {
+		scheme, security = scheme[:i], scheme[i+1:]
+	}
+	switch scheme {
+	case "bolt", "neo4j", "bolt+routing":
+	default:
+		return "", nil, fmt.Errorf("unsupported db url scheme %s", parsed.Scheme)
+	}
+
+	switch security {
+	case "":
+		configure = func(c *neo4j.Config) {
+			c.Encrypted = false
+		}
+	case "s":
+		configure = func(c *neo4j.Config) {
+			c.Encrypted = true
+			c.TrustStrategy = neo4j.TrustSystem(true)
+		}
+	case "ssc":
+		configure = func(c *neo4j.Config) {
+			c.Encrypted = true
+			c.TrustStrategy = neo4j.TrustAny(false)
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported db url scheme %s", parsed.Scheme)
+	}
+
+	parsed.Scheme = scheme
+	return parsed.String(), configure, nil
+}
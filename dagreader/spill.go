@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/Fantom-foundation/go-opera/inter"
+	"github.com/Fantom-foundation/go-opera/logger"
+	"github.com/Fantom-foundation/lachesis-base/hash"
+	"github.com/Fantom-foundation/lachesis-base/inter/idx"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+)
+
+// spillQueue passes events from producer to db without blocking the producer:
+// events which don't fit in memory are spilled to a temporary file
+// and are read back in the same order when db catches up.
+// Spilled event takes about 1KB of disk space, the file is removed on close.
+type spillQueue struct {
+	in  chan *internal.EventInfo
+	out chan *internal.EventInfo
+
+	mem      []*internal.EventInfo
+	memLimit int
+
+	file      *os.File
+	spilled   int
+	rpos      int64
+	wpos      int64
+	head      *internal.EventInfo
+	disposals map[hash.Event]func()
+
+	logger.Instance
+}
+
+type spillRecord struct {
+	Block idx.Block
+	Role  string
+	Event map[string]interface{}
+}
+
+// newSpillQueue keeps up to memLimit events in memory.
+// When done is closed the queued events are dropped instead of being passed,
+// onDrop is called for each of them.
+func newSpillQueue(memLimit int, done <-chan struct{}, onDrop func()) *spillQueue {
+	q := &spillQueue{
+		in:        make(chan *internal.EventInfo),
+		out:       make(chan *internal.EventInfo),
+		memLimit:  memLimit,
+		disposals: make(map[hash.Event]func()),
+		Instance:  logger.New("spill"),
+	}
+
+	go q.loop(done, onDrop)
+
+	return q
+}
+
+// In events, close it to finish the queue.
+func (q *spillQueue) In() chan<- *internal.EventInfo {
+	return q.in
+}
+
+// Out events, it is closed when all the events are passed.
+func (q *spillQueue) Out() <-chan *internal.EventInfo {
+	return q.out
+}
+
+func (q *spillQueue) loop(done <-chan struct{}, onDrop func()) {
+	defer close(q.out)
+	defer q.removeFile()
+
+	in := q.in
+	for in != nil || q.len() > 0 {
+		var (
+			out  chan<- *internal.EventInfo
+			next *internal.EventInfo
+		)
+		if q.len() > 0 {
+			next = q.peek()
+			out = q.out
+		}
+
+		select {
+		case e, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			q.push(e)
+		case out <- next:
+			q.pop()
+		case <-done:
+			q.drop(in, onDrop)
+			return
+		}
+	}
+}
+
+// drop the queued events and the rest of input on interruption.
+func (q *spillQueue) drop(in <-chan *internal.EventInfo, onDrop func()) {
+	dropped := q.len()
+	q.mem = nil
+	q.spilled = 0
+	if in != nil {
+		for range in {
+			dropped++
+		}
+	}
+	for i := 0; i < dropped; i++ {
+		onDrop()
+	}
+	if dropped > 0 {
+		q.Log.Warn("interrupted, events are dropped", "count", dropped)
+	}
+}
+
+func (q *spillQueue) len() int {
+	return len(q.mem) + q.spilled
+}
+
+func (q *spillQueue) push(e *internal.EventInfo) {
+	if q.spilled == 0 && len(q.mem) < q.memLimit {
+		q.mem = append(q.mem, e)
+		return
+	}
+
+	event, ok := e.Event.(inter.EventI)
+	if !ok {
+		panic("unsupported event type")
+	}
+	bb, err := json.Marshal(spillRecord{
+		Block: e.Block,
+		Role:  e.Role,
+		Event: inter.RPCMarshalEvent(event),
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	if q.file == nil {
+		q.file, err = ioutil.TempFile("", "dagreader-spill-")
+		if err != nil {
+			panic(err)
+		}
+		q.Log.Warn("db is slow, spill events", "file", q.file.Name())
+	}
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(bb)))
+	q.write(size[:])
+	q.write(bb)
+
+	if e.Dispose != nil {
+		q.disposals[e.Event.ID()] = e.Dispose
+	}
+	q.spilled++
+}
+
+func (q *spillQueue) peek() *internal.EventInfo {
+	if len(q.mem) > 0 {
+		return q.mem[0]
+	}
+	if q.head != nil {
+		return q.head
+	}
+
+	var size [4]byte
+	q.read(size[:])
+	bb := make([]byte, binary.BigEndian.Uint32(size[:]))
+	q.read(bb)
+
+	var rec spillRecord
+	err := json.Unmarshal(bb, &rec)
+	if err != nil {
+		panic(err)
+	}
+	event := inter.RPCUnmarshalEvent(rec.Event)
+	q.head = &internal.EventInfo{
+		Block:   rec.Block,
+		Role:    rec.Role,
+		Event:   event,
+		Dispose: q.disposals[event.ID()],
+	}
+	delete(q.disposals, event.ID())
+
+	return q.head
+}
+
+func (q *spillQueue) pop() {
+	if len(q.mem) > 0 {
+		q.mem[0] = nil
+		q.mem = q.mem[1:]
+		return
+	}
+
+	q.head = nil
+	q.spilled--
+	if q.spilled == 0 {
+		// all the spilled events are read, so reuse the file from the start
+		err := q.file.Truncate(0)
+		if err != nil {
+			panic(err)
+		}
+		q.rpos, q.wpos = 0, 0
+		q.Log.Info("spilled events are drained")
+	}
+}
+
+func (q *spillQueue) write(bb []byte) {
+	n, err := q.file.WriteAt(bb, q.wpos)
+	if err != nil {
+		panic(err)
+	}
+	q.wpos += int64(n)
+}
+
+func (q *spillQueue) read(bb []byte) {
+	n, err := q.file.ReadAt(bb, q.rpos)
+	if err != nil {
+		panic(err)
+	}
+	q.rpos += int64(n)
+}
+
+func (q *spillQueue) removeFile() {
+	if q.file == nil {
+		return
+	}
+	name := q.file.Name()
+	q.file.Close()
+	err := os.Remove(name)
+	if err != nil {
+		q.Log.Error("remove spill file", "file", name, "err", err)
+	}
+}
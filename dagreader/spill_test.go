@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/internal"
+	"github.com/Fantom-foundation/lachesis-dag-tool/dagreader/neo4j"
+)
+
+func TestSpillQueueOrder(t *testing.T) {
+	require := require.New(t)
+
+	events := neo4j.SyntheticDAG(1, 5, 100, 0)
+	disposed := make([]bool, len(events))
+	q := newSpillQueue(7, nil, func() {})
+
+	push := func(from, to int) {
+		for i := from; i < to; i++ {
+			i := i
+			q.In() <- &internal.EventInfo{
+				Block:   1,
+				Role:    "atropos",
+				Event:   events[i],
+				Dispose: func() { disposed[i] = true },
+			}
+		}
+	}
+
+	// nobody reads, so the most of them are spilled
+	push(0, 50)
+	go func() {
+		push(50, len(events))
+		close(q.In())
+	}()
+
+	i := 0
+	for info := range q.Out() {
+		require.Equal(events[i].ID(), info.Event.ID(), i)
+		require.Equal("atropos", info.Role)
+		info.Done()
+		i++
+	}
+	require.Equal(len(events), i)
+	for i, ok := range disposed {
+		require.True(ok, i)
+	}
+}
+
+func TestSpillQueueInterrupt(t *testing.T) {
+	require := require.New(t)
+
+	events := neo4j.SyntheticDAG(1, 5, 50, 0)
+	done := make(chan struct{})
+	var dropped int64
+	q := newSpillQueue(7, done, func() {
+		atomic.AddInt64(&dropped, 1)
+	})
+
+	for _, e := range events {
+		q.In() <- &internal.EventInfo{Block: 1, Event: e}
+	}
+	close(done)
+	close(q.In())
+
+	passed := 0
+	for range q.Out() {
+		passed++
+	}
+	// a few events may be passed before interruption is noticed
+	require.Less(passed, len(events))
+	require.Equal(int64(len(events)-passed), atomic.LoadInt64(&dropped))
+}